@@ -0,0 +1,234 @@
+package wire9
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+)
+
+// BitOrder is the order in which consecutive bit fields are packed
+// into bytes.
+type BitOrder int
+
+// BitOrder values
+const (
+	MSBFirst BitOrder = iota // first field in the most significant bits
+	LSBFirst                 // first field in the least significant bits
+)
+
+// maxBits is the width of the largest group of bit fields
+const maxBits = 64
+
+// BitOrderOf returns the bit order named by s. The second value is
+// false if s is not "MSB" or "LSB".
+func BitOrderOf(s string) (BitOrder, bool) {
+	switch s {
+	case "MSB":
+		return MSBFirst, true
+	case "LSB":
+		return LSBFirst, true
+	}
+	return MSBFirst, false
+}
+
+// TypeFromBits returns the smallest unsigned type holding n bits
+func TypeFromBits(n int) ast.Expr {
+	switch {
+	case n <= 8:
+		return &ast.Ident{Name: "byte"}
+	case n <= 16:
+		return &ast.Ident{Name: "uint16"}
+	case n <= 32:
+		return &ast.Ident{Name: "uint32"}
+	}
+	return &ast.Ident{Name: "uint64"}
+}
+
+// bitTypes maps the types a bit field may have to their width in bits
+var bitTypes = map[string]int{
+	"bool":   1,
+	"byte":   8,
+	"uint8":  8,
+	"uint16": 16,
+	"uint32": 32,
+	"uint64": 64,
+}
+
+// layoutBits assigns shifts to consecutive runs of bit fields, and marks
+// the first field of each run with the number of bytes in the run. It
+// returns an error if a run does not end on a byte boundary or is wider
+// than 64 bits.
+func layoutBits(fields []*ast.Field, infos []*Info, order BitOrder) error {
+	name := func(i int) string {
+		if infos[i].Pad {
+			return "_"
+		}
+		return fields[i].Names[0].Name
+	}
+	for i := 0; i < len(infos); {
+		if infos[i] == nil || infos[i].Bits == 0 {
+			i++
+			continue
+		}
+		j, total := i, 0
+		for ; j < len(infos) && infos[j] != nil && infos[j].Bits != 0; j++ {
+			total += infos[j].Bits
+		}
+		if total%8 != 0 {
+			return fmt.Errorf("bit fields %s..%s: %d bits is not a whole number of bytes",
+				name(i), name(j-1), total)
+		}
+		if total > maxBits {
+			return fmt.Errorf("bit fields %s..%s: %d bits is wider than %d",
+				name(i), name(j-1), total, maxBits)
+		}
+		infos[i].Group = total / 8
+		off := 0
+		for k := i; k < j; k++ {
+			infos[k].BitOrder = order
+			if order == MSBFirst {
+				infos[k].Shift = total - off - infos[k].Bits
+			} else {
+				infos[k].Shift = off
+			}
+			off += infos[k].Bits
+		}
+		i = j
+	}
+	return nil
+}
+
+// BitField returns true if f is a bit field
+func BitField(ts *ast.TypeSpec, f *ast.Field) bool {
+	info := TInfo.Get(ts, f)
+	return info != nil && info.Bits != 0
+}
+
+// bitGroup returns the fields packed together with f if f is the first
+// field of a run of bit fields.
+func bitGroup(ts *ast.TypeSpec, f *ast.Field) (group []*ast.Field, first *Info) {
+	first = TInfo.Get(ts, f)
+	if first == nil || first.Group == 0 {
+		return nil, nil
+	}
+	fields := ts.Type.(*ast.StructType).Fields.List
+	for i, g := range fields {
+		if g != f {
+			continue
+		}
+		for bits := 0; bits < first.Group*8; i++ {
+			group = append(group, fields[i])
+			bits += TInfo.Get(ts, fields[i]).Bits
+		}
+		break
+	}
+	return group, first
+}
+
+// unpackBits writes statements assembling the bytes in buf into
+// bits and extracting each field of the group from it.
+func unpackBits(b *bytes.Buffer, ts *ast.TypeSpec, group []*ast.Field, first *Info) {
+	fmt.Fprintf(b, "var bits uint64\n")
+	if first.BitOrder == MSBFirst {
+		fmt.Fprintf(b, "for _, c := range buf {\nbits = bits<<8 | uint64(c)\n}\n")
+	} else {
+		fmt.Fprintf(b, "for i := len(buf) - 1; i >= 0; i-- {\nbits = bits<<8 | uint64(buf[i])\n}\n")
+	}
+	for _, f := range group {
+		info := TInfo.Get(ts, f)
+		if info.Pad {
+			continue
+		}
+		mask := uint64(1)<<uint(info.Bits) - 1
+		if typ := TypeString(f.Type); typ == "bool" {
+			fmt.Fprintf(b, "z.%s = bits>>%d&%#x != 0\n", f.Names[0].Name, info.Shift, mask)
+		} else {
+			fmt.Fprintf(b, "z.%s = %s(bits >> %d & %#x)\n", f.Names[0].Name, typ, info.Shift, mask)
+		}
+	}
+}
+
+// packBits writes statements packing each field of the group into bits
+// and storing the result in buf.
+func packBits(b *bytes.Buffer, ts *ast.TypeSpec, group []*ast.Field, first *Info) {
+	fmt.Fprintf(b, "var bits uint64\n")
+	for _, f := range group {
+		info := TInfo.Get(ts, f)
+		if info.Pad {
+			continue
+		}
+		mask := uint64(1)<<uint(info.Bits) - 1
+		if TypeString(f.Type) == "bool" {
+			fmt.Fprintf(b, "if z.%s {\nbits |= 1 << %d\n}\n", f.Names[0].Name, info.Shift)
+		} else {
+			fmt.Fprintf(b, "bits |= (uint64(z.%s) & %#x) << %d\n", f.Names[0].Name, mask, info.Shift)
+		}
+	}
+	fmt.Fprintf(b, "var buf [%d]byte\n", first.Group)
+	if first.BitOrder == MSBFirst {
+		fmt.Fprintf(b, "for i := range buf {\nbuf[i] = byte(bits >> (8 * (%d - i)))\n}\n", first.Group-1)
+	} else {
+		fmt.Fprintf(b, "for i := range buf {\nbuf[i] = byte(bits >> (8 * i))\n}\n")
+	}
+}
+
+// ReadBits returns a block reading the group of bit fields starting at f
+// from r. It returns an empty string for the other fields of the group.
+func ReadBits(ts *ast.TypeSpec, f *ast.Field) string {
+	group, first := bitGroup(ts, f)
+	if group == nil {
+		return ""
+	}
+	b := new(bytes.Buffer)
+	fmt.Fprintf(b, "{\nvar buf [%d]byte\n", first.Group)
+	fmt.Fprintf(b, "if m, err := io.ReadFull(r, buf[:]); err == io.ErrUnexpectedEOF || err == io.EOF && rc.N != 0 {\n")
+	fmt.Fprintf(b, "return ioErr(\"read\", m, %d)\n} else if err != nil {\nreturn err\n}\n", first.Group)
+	unpackBits(b, ts, group, first)
+	fmt.Fprintf(b, "}\n")
+	return b.String()
+}
+
+// WriteBits returns a block writing the group of bit fields starting at
+// f to w. It returns an empty string for the other fields of the group.
+func WriteBits(ts *ast.TypeSpec, f *ast.Field) string {
+	group, first := bitGroup(ts, f)
+	if group == nil {
+		return ""
+	}
+	b := new(bytes.Buffer)
+	fmt.Fprintf(b, "{\n")
+	packBits(b, ts, group, first)
+	fmt.Fprintf(b, "if _, err := w.Write(buf[:]); err != nil {\nreturn err\n}\n}\n")
+	return b.String()
+}
+
+// DecodeBits returns a block decoding the group of bit fields starting
+// at f from b[n:]. It returns an empty string for the other fields of
+// the group.
+func DecodeBits(ts *ast.TypeSpec, f *ast.Field) string {
+	group, first := bitGroup(ts, f)
+	if group == nil {
+		return ""
+	}
+	b := new(bytes.Buffer)
+	fmt.Fprintf(b, "{\nif len(b)-n < %d {\nreturn n, ioErr(\"read\", len(b)-n, %d)\n}\n", first.Group, first.Group)
+	fmt.Fprintf(b, "buf := b[n : n+%d]\n", first.Group)
+	unpackBits(b, ts, group, first)
+	fmt.Fprintf(b, "n += %d\n}\n", first.Group)
+	return b.String()
+}
+
+// AppendBits returns a block appending the group of bit fields starting
+// at f to b. It returns an empty string for the other fields of the
+// group.
+func AppendBits(ts *ast.TypeSpec, f *ast.Field) string {
+	group, first := bitGroup(ts, f)
+	if group == nil {
+		return ""
+	}
+	b := new(bytes.Buffer)
+	fmt.Fprintf(b, "{\n")
+	packBits(b, ts, group, first)
+	fmt.Fprintf(b, "b = append(b, buf[:]...)\n}\n")
+	return b.String()
+}
@@ -0,0 +1,85 @@
+package wire9
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"strconv"
+	"strings"
+)
+
+// constBytes returns the encoding of the constant lit in a field of n
+// bytes. Strings are padded with zeros, and integers are written in the
+// byte order of the field.
+func constBytes(lit *ast.BasicLit, n int, order binary.ByteOrder) (string, error) {
+	switch lit.Kind {
+	case token.STRING:
+		s, err := strconv.Unquote(lit.Value)
+		if err != nil {
+			return "", err
+		}
+		if len(s) > n {
+			return "", fmt.Errorf("constant %s is longer than %d bytes", lit.Value, n)
+		}
+		return s + strings.Repeat("\x00", n-len(s)), nil
+	case token.INT:
+		v, ok := constant.Uint64Val(constant.MakeFromLiteral(lit.Value, token.INT, 0))
+		if !ok || n > 8 || n < 8 && v>>(8*uint(n)) != 0 {
+			return "", fmt.Errorf("constant %s does not fit in %d bytes", lit.Value, n)
+		}
+		var buf [8]byte
+		if order == binary.BigEndian {
+			binary.BigEndian.PutUint64(buf[:], v)
+			return string(buf[8-n:]), nil
+		}
+		binary.LittleEndian.PutUint64(buf[:], v)
+		return string(buf[:n]), nil
+	}
+	return "", fmt.Errorf("constant %s must be a string or integer", lit.Value)
+}
+
+// IsConst returns true if f holds a constant, as in magic[4,"\x7fELF"].
+// Constant fields have no struct field.
+func IsConst(ts *ast.TypeSpec, f *ast.Field) bool {
+	info := TInfo.Get(ts, f)
+	return info != nil && info.Const != nil
+}
+
+// ConstField returns a block reading, writing, decoding or appending
+// the constant field f, as selected by method. On Read and Decode, the
+// block fails if the input holds a different value.
+func ConstField(ts *ast.TypeSpec, f *ast.Field, method string) (string, error) {
+	info := TInfo.Get(ts, f)
+	n, _ := ConstWidth(info.Width)
+	s, err := constBytes(info.Const, n, info.Endian)
+	if err != nil {
+		return "", fmt.Errorf("%s.%s: %v", ts.Name.Name, f.Names[0].Name, err)
+	}
+	verb := "%#x"
+	if info.Const.Kind == token.STRING {
+		verb = "%q"
+	}
+	lit := strconv.Quote(s)
+	b := new(bytes.Buffer)
+	switch method {
+	case "Read":
+		fmt.Fprintf(b, "var buf [%d]byte\n", n)
+		fmt.Fprintf(b, "if m, err := io.ReadFull(r, buf[:]); err == io.ErrUnexpectedEOF || err == io.EOF && rc.N != 0 {\n")
+		fmt.Fprintf(b, "return ioErr(\"read\", m, %d)\n} else if err != nil {\nreturn err\n}\n", n)
+		fmt.Fprintf(b, "if string(buf[:]) != %s {\n", lit)
+		fmt.Fprintf(b, "return fmt.Errorf(\"have %s, want %s\", buf[:], %s)\n}\n", verb, verb, lit)
+	case "Write":
+		fmt.Fprintf(b, "if _, err := io.WriteString(w, %s); err != nil {\nreturn err\n}\n", lit)
+	case "Decode":
+		fmt.Fprintf(b, "if len(b)-n < %d {\nreturn n, ioErr(\"read\", len(b)-n, %d)\n}\n", n, n)
+		fmt.Fprintf(b, "if string(b[n:n+%d]) != %s {\n", n, lit)
+		fmt.Fprintf(b, "return n, fmt.Errorf(\"have %s, want %s\", b[n:n+%d], %s)\n}\n", verb, verb, n, lit)
+		fmt.Fprintf(b, "n += %d\n", n)
+	case "Append":
+		fmt.Fprintf(b, "b = append(b, %s...)\n", lit)
+	}
+	return b.String(), nil
+}
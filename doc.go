@@ -10,6 +10,13 @@ directory containing wire definitions.
 	cd $GOPATH/src/github.com/as/wire9/
 	wire9 -f example/0/ex_wire9.go example/0/
 
+The output file belongs to the same package as the input files. It
+imports only the packages its code refers to, including packages
+named in field types such as image.Point; their import paths are
+taken from the input files. A field type from a package whose name
+the generated code uses for another package, such as a local bytes,
+is an error.
+
 Wire Definitions:
 
 A wire definition begins with a slash comment and wire9 prefix. There is
@@ -32,12 +39,24 @@ final struct field, numeric and fixed types may have empty widths.
 Endian specifies byte-order (LE or BE), which stand for Little-Endian
 and Big-Endian. Little-Endian is the default value.
 
+The default can be changed for all subsequent definitions in a file
+with an endian directive, or for a single definition by placing the
+byte order in angle brackets after its name:
+
+	//wire9:endian BE
+	//wire9 Hdr      size[4] type[1]
+	//wire9 Trailer<LE> crc[4]
+
 The width and type are interpreted one of three ways depending on
 other values in the field options:
 
 	1. Width is a numeric literal
 		A. Type is empty: If width is 1, 2, 4, or 8, type is byte, uint16,
-           uint32, and uint64, respectively. Otherwise type is []byte.
+           uint32, and uint64, respectively. Widths of 3 and 5 to 7 are
+           integers held in the next larger type. Otherwise type is []byte.
+
+		   An integer type wider than the width holds a narrower integer,
+		   as in len[3] or off[3,int32]. Signed values are sign extended.
 
 		B. Type is identifier: The type represents a fixed width struct, numeric value, or slice
            type. The width must match the fixed types binary width or be the number of expected
@@ -52,6 +71,16 @@ other values in the field options:
 
 	3. Width is empty
 		A. Type is a fixed-width struct, number, or implements the Wire interface.
+		   An array type's width is its length.
+
+	Slices and arrays of numeric types are read and written in a single
+	buffer, with each element in the field's byte order. The types int,
+	uint and uintptr have no fixed width and are an error.
+
+	Array types stay arrays in the struct, as in id[16,[16]byte]. They are
+	read in place without allocating, and a struct of arrays and numbers
+	is comparable and may be a map key. The -a flag makes a field with a
+	literal width over 8 bytes and no type a byte array instead of a slice.
 
 	Examples:
 
@@ -63,8 +92,140 @@ other values in the field options:
 
 	//wire9 Ex3A p[,image.Point]  size[,int64]  reply[,Ex2A]
 
+	//wire9 Ex4 n[2] vals[n,[]uint32,BE] rgb[,[3]float32]
+	//wire9 Ex5 id[16,[16]byte] pts[,[4]image.Point]
+
 	//wire9 Git index[4,,BE] ...
 
+Constants:
+
+A string or integer literal in place of the type makes a constant
+field, such as a magic number or version. It has no struct field;
+WriteBinary writes the constant, and ReadBinary fails if the input
+holds anything else. Strings shorter than the width are padded with
+zeros, and the width of a string may be omitted. Integers are written
+in the field's byte order.
+
+	//wire9 Elf magic[4,"\x7fELF"] class[1]
+	//wire9 Hdr ver[1,0x02] tag[2,0xcafe,BE] name[8,"wire"]
+
+Padding:
+
+A field named _ is padding. It has no struct field; ReadBinary skips
+it and WriteBinary writes zeros. Bit fields may be padding as well. The
+field align(n) pads the message to the next multiple of n bytes from
+its start.
+
+	//wire9 Hdr kind[1] _[3] len[4]
+	//wire9 Xdr n[4,,BE] data[n] align(4) tag[2] _[2b] flags[6b]
+
+Varints:
+
+The width keywords uvarint and svarint make a field a variable-length
+integer of type varint.V or varint.S, from github.com/as/wire9/varint.
+Signed varints are zig-zag encoded. A varint may be the count of other
+fields, and has no type or byte order of its own. The other integer
+types of the package count fields too, and SetLengths fails on lengths
+too large for them.
+
+	//wire9 Rec n[uvarint] data[n] delta[svarint]
+	//wire9 Blob n[,varint.V32] data[n]
+
+Unions:
+
+A field whose width is a preceding tag field and whose type is a switch
+holds one of several types, selected by the tag's value:
+
+	//wire9 Msg kind[1] body[kind, switch{1:Tversion, 2:Rversion, default:Raw}]
+
+The field's type is an interface, MsgBody, holding a pointer to one of
+the listed types. ReadBinary reads the tag and then a value of the type
+it selects; an unknown tag is an error unless there is a default.
+WriteBinary sets the tag from the value's dynamic type, or verifies it
+when the type is the default or is listed for several tags.
+
+Bit Fields:
+
+A width followed by b is a number of bits. Consecutive bit fields are
+packed together and must add up to a whole number of bytes, at most
+eight. A bit field's type defaults to the smallest unsigned integer
+holding it, and may be any unsigned integer type or bool.
+
+	//wire9 IPv4 version[4b] ihl[4b] dscp[6b] ecn[2b] length[2,,BE] ...
+
+Bit fields are packed most significant bit first by default, so the
+first field occupies the high bits of the first byte. The bitorder
+directive, or MSB and LSB in a definition's angle brackets, select the
+order:
+
+	//wire9:bitorder LSB
+	//wire9 Flags<BE,MSB> a[1b,bool] b[7b]
+
+Conditional Fields:
+
+The option "if expr", given after the endian, makes a field present
+only when expr holds. The expression may refer to preceding fields;
+one that is not a comparison holds when it is non-zero.
+
+	//wire9 Opt flags[1] ext[4,,BE,if flags&0x80] n[1] data[n,,,if n > 0]
+
+An absent field is neither read nor written, and ReadBinary leaves
+its value unchanged. Bit fields cannot be conditional.
+
+Rest of Input:
+
+A width of * makes the last field, a []byte, hold the rest of the
+input: ReadBinary reads until EOF and DecodeFrom takes the remainder
+of b. A width may also be an expression over preceding fields, such as
+the payload left after a header counted in an enclosing size:
+
+	//wire9 Tail kind[1] payload[*]
+	//wire9 Frame size[4,,BE] kind[4] payload[size-8]
+
+Frames:
+
+The option frame marks an integer field holding the length of the
+whole message, including itself and any constant-width fields before
+it. ReadBinary and DecodeFrom decode the rest of the message from
+exactly that many bytes, and fail if any are left over. WriteBinary
+and AppendBinary set the field to the length of the encoding.
+
+	//wire9 Rmsg size[4,,,frame] type[1] tag[2] payload[*]
+
+Lengths:
+
+A field naming the width of one or more slices is their count. The
+generated SetLengths method sets every count from the length of its
+slices, and returns an error if they differ, the length does not fit
+the count, or the count is absent and the length is not zero.
+WriteBinary and AppendBinary call it before writing, and BinarySize
+sizes the counts as they would be written.
+
+	//wire9 Pair n[2] keys[n] vals[n,[]Str]
+
+The max option limits the number of elements a slice with a variable
+width may hold. Decoding a larger width returns a *wire.LengthError
+before anything is allocated. A field holding the rest of the input
+takes a max too, and ReadBinary reads at most one byte more than it.
+The -max flag sets a limit for every such field without its own. Large
+byte slices are allocated as their data arrives.
+
+	//wire9 Msg n[4] data[n,,,max=65536]
+	//wire9 Tail kind[1] payload[*,,,max=512]
+
+Errors:
+
+ReadBinary and DecodeFrom return a *wire.DecodeError naming the
+definition and field that failed, and the field's offset from the start
+of the message. The underlying error is available with errors.Is and
+errors.As. ReadBinary returns io.EOF unchanged when no bytes were read,
+and an error wrapping io.ErrUnexpectedEOF when the input ends inside a
+message. Reads use io.ReadFull, so short reads from pipes and network
+connections are retried.
+
+The error types and helpers of generated code are in package
+github.com/as/wire9/wire, which depends only on the standard library.
+
 Example:
 
 The wire definition for a two-byte length-prefixed string:
@@ -79,6 +240,31 @@ Generates the following struct and (fully-implemented) methods:
 	}
 	func (z *Bstr) ReadBinary(r io.Reader) (err error)
 	func (z Bstr)  WriteBinary(w io.Writer) (err error)
+	func (z Bstr)  MarshalBinary() ([]byte, error)
+	func (z *Bstr) UnmarshalBinary(data []byte) error
+
+MarshalBinary and UnmarshalBinary implement encoding.BinaryMarshaler and
+encoding.BinaryUnmarshaler. UnmarshalBinary fails if bytes remain after
+the last field is read.
+
+Every definition also gets a BinarySize method returning the length of
+its encoding. If every field has a constant width, a constant is
+generated as well:
+
+	//wire9 Point X[4] Y[4]
+
+	const PointSize = 8
+	func (z Point) BinarySize() int
+
+The -s flag additionally generates a slice codec that avoids io.Reader,
+io.Writer and reflection:
+
+	func (z *Bstr) DecodeFrom(b []byte) (n int, err error)
+	func (z Bstr)  AppendBinary(b []byte) ([]byte, error)
+
+DecodeFrom returns the number of bytes consumed from b, and reuses the
+capacity of slices already present in z. Nested custom types must also
+have a slice codec.
 
 This example defines four common length-prefixed strings
 
@@ -57,12 +57,12 @@ func (v DupMap) Visit(n ast.Node) ast.Visitor {
 		if n.Recv == nil {
 			return nil
 		}
-		recv := fmt.Sprint(n.Recv.List[0].Type)
+		recv := recvName(n.Recv.List[0].Type)
 		fn := n.Name.Name
-		if fn == "WriteBinary" {
-			v[Dup{fn, "WriteBinary"}] = n.Name
-		} else if fn == "ReadBinary" {
-			v[Dup{fn, "ReadBinary"}] = n.Name
+		switch fn {
+		case "WriteBinary", "ReadBinary", "MarshalBinary", "UnmarshalBinary",
+			"BinarySize", "SetLengths", "DecodeFrom", "AppendBinary":
+			v[Dup{recv, fn}] = ast.NewIdent(recv)
 		}
 		fmt.Println("Found", fn, "for", recv)
 		return nil
@@ -70,6 +70,17 @@ func (v DupMap) Visit(n ast.Node) ast.Visitor {
 	return nil
 }
 
+// recvName returns the name of the type in a method receiver
+func recvName(x ast.Expr) string {
+	switch t := x.(type) {
+	case *ast.StarExpr:
+		return recvName(t.X)
+	case *ast.Ident:
+		return t.Name
+	}
+	return fmt.Sprint(x)
+}
+
 func (v DupMap) Merge(v2 DupMap) {
 	for key, val := range v2{
 		v[key] = val
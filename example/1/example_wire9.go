@@ -3,10 +3,12 @@
 package main
 
 import (
-	"encoding/binary"
 	"bytes"
-	"io"
+	"encoding/binary"
 	"fmt"
+	"io"
+
+	"github.com/as/wire9/wire"
 )
 
 func writestring(w io.Writer, s string, must int) (err error) {
@@ -27,66 +29,90 @@ func writestring(w io.Writer, s string, must int) (err error) {
 	return err
 }
 
-func ioErr(name, kind string, ac, ex int) error {
-	return fmt.Errorf("%s: short %s: %d/%d", name, kind, ac, ex)
+func ioErr(kind string, ac, ex int) error {
+	return fmt.Errorf("short %s: %d/%d bytes: %w", kind, ac, ex, io.ErrUnexpectedEOF)
 }
 
 type Pstr struct {
-	n	byte
-	data	[]byte
+	n    byte
+	data []byte
 }
 
 type Bstr struct {
-	n	uint16
-	data	[]byte
+	n    uint16
+	data []byte
 }
 
 type Mestr struct {
-	n	uint32
-	data	[]byte
+	n    uint32
+	data []byte
 }
 
 type u64s struct {
-	n	uint64
-	data	[]byte
+	n    uint64
+	data []byte
 }
 
 type i64s struct {
-	n	int64
-	data	[]byte
+	n    int64
+	data []byte
 }
 
 type BBEStr struct {
-	n	int64
-	data	[]byte
+	n    int64
+	data []byte
 }
 
 type ApeStr struct {
-	n	uint16
-	data	[]Pstr
+	n    uint16
+	data []Pstr
 }
 
 func (z *Pstr) ReadBinary(r io.Reader) (err error) {
-	defer func() { recover() }()
 	if z == nil {
 		return fmt.Errorf("ReadBinary: z nil")
 	}
+	rc := &wire.Counter{R: r}
+	r = rc
+	var field string
+	var off int64
+	defer func() {
+		if err == io.EOF && rc.N != 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil && err != io.EOF {
+			err = wire.Annotate(err, "Pstr", field, off)
+		}
+	}()
+	field, off = "n", rc.N
 
-	if err := binary.Read(r, binary.LittleEndian, &z.n); err != nil {
+	if err := binary.Read(r, binary.LittleEndian, &z.n); err == io.ErrUnexpectedEOF || err == io.EOF && rc.N != 0 {
+		return ioErr("read", int(rc.N-off), binary.Size(z.n))
+	} else if err != nil {
 		return err
 	}
 
-	z.data = make([]byte, int(z.n))
+	field, off = "data", rc.N
 
-	if n, err := r.Read(z.data); err != nil || n != int(z.n) {
-		return err
+	{
+		x := int(z.n)
+		if x < 0 {
+			return fmt.Errorf("negative width %d", x)
+		}
+		if z.data, err = wire.ReadN(r, x); err == io.ErrUnexpectedEOF || err == io.EOF && rc.N != 0 {
+			return ioErr("read", len(z.data), x)
+		} else if err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
 func (z *Pstr) WriteBinary(w io.Writer) (err error) {
-	defer func() { recover() }()
+	if err := z.SetLengths(); err != nil {
+		return err
+	}
 
 	if err := binary.Write(w, binary.LittleEndian, z.n); err != nil {
 		return err
@@ -94,34 +120,105 @@ func (z *Pstr) WriteBinary(w io.Writer) (err error) {
 
 	{
 		x := int(z.n)
-		if n, err := w.Write(z.data[:x]); err != nil || n != x {
+		if x < 0 {
+			return fmt.Errorf("Pstr.data: negative width %d", x)
+		}
+		if len(z.data) < x {
+			return fmt.Errorf("Pstr.data: have %d bytes, want %d", len(z.data), x)
+		}
+		if _, err := w.Write(z.data[:x]); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// MarshalBinary implements encoding.BinaryMarshaler
+func (z Pstr) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := z.WriteBinary(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler. It
+// returns an error if data is not consumed entirely.
+func (z *Pstr) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	if err := z.ReadBinary(r); err != nil {
+		return err
+	}
+	if n := r.Len(); n != 0 {
+		return fmt.Errorf("Pstr: UnmarshalBinary: %d trailing bytes", n)
+	}
+	return nil
+}
+
+// BinarySize returns the length of z's binary encoding
+func (z Pstr) BinarySize() (n int) {
+	// Size the counts as WriteBinary writes them; z is a copy.
+	z.SetLengths()
+	n += 1
+	n += int(z.n)
+	return n
+}
+
+// SetLengths sets the count fields of z from the lengths of the
+// slices they count. WriteBinary calls it before writing.
+func (z *Pstr) SetLengths() error {
+	if uint64(len(z.data)) > 0xff {
+		return fmt.Errorf("Pstr.n: length %d is too large", len(z.data))
+	}
+	z.n = byte(len(z.data))
+	return nil
+}
+
 func (z *Bstr) ReadBinary(r io.Reader) (err error) {
-	defer func() { recover() }()
 	if z == nil {
 		return fmt.Errorf("ReadBinary: z nil")
 	}
+	rc := &wire.Counter{R: r}
+	r = rc
+	var field string
+	var off int64
+	defer func() {
+		if err == io.EOF && rc.N != 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil && err != io.EOF {
+			err = wire.Annotate(err, "Bstr", field, off)
+		}
+	}()
+	field, off = "n", rc.N
 
-	if err := binary.Read(r, binary.LittleEndian, &z.n); err != nil {
+	if err := binary.Read(r, binary.LittleEndian, &z.n); err == io.ErrUnexpectedEOF || err == io.EOF && rc.N != 0 {
+		return ioErr("read", int(rc.N-off), binary.Size(z.n))
+	} else if err != nil {
 		return err
 	}
 
-	z.data = make([]byte, int(z.n))
+	field, off = "data", rc.N
 
-	if n, err := r.Read(z.data); err != nil || n != int(z.n) {
-		return err
+	{
+		x := int(z.n)
+		if x < 0 {
+			return fmt.Errorf("negative width %d", x)
+		}
+		if z.data, err = wire.ReadN(r, x); err == io.ErrUnexpectedEOF || err == io.EOF && rc.N != 0 {
+			return ioErr("read", len(z.data), x)
+		} else if err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
 func (z *Bstr) WriteBinary(w io.Writer) (err error) {
-	defer func() { recover() }()
+	if err := z.SetLengths(); err != nil {
+		return err
+	}
 
 	if err := binary.Write(w, binary.LittleEndian, z.n); err != nil {
 		return err
@@ -129,34 +226,105 @@ func (z *Bstr) WriteBinary(w io.Writer) (err error) {
 
 	{
 		x := int(z.n)
-		if n, err := w.Write(z.data[:x]); err != nil || n != x {
+		if x < 0 {
+			return fmt.Errorf("Bstr.data: negative width %d", x)
+		}
+		if len(z.data) < x {
+			return fmt.Errorf("Bstr.data: have %d bytes, want %d", len(z.data), x)
+		}
+		if _, err := w.Write(z.data[:x]); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// MarshalBinary implements encoding.BinaryMarshaler
+func (z Bstr) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := z.WriteBinary(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler. It
+// returns an error if data is not consumed entirely.
+func (z *Bstr) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	if err := z.ReadBinary(r); err != nil {
+		return err
+	}
+	if n := r.Len(); n != 0 {
+		return fmt.Errorf("Bstr: UnmarshalBinary: %d trailing bytes", n)
+	}
+	return nil
+}
+
+// BinarySize returns the length of z's binary encoding
+func (z Bstr) BinarySize() (n int) {
+	// Size the counts as WriteBinary writes them; z is a copy.
+	z.SetLengths()
+	n += 2
+	n += int(z.n)
+	return n
+}
+
+// SetLengths sets the count fields of z from the lengths of the
+// slices they count. WriteBinary calls it before writing.
+func (z *Bstr) SetLengths() error {
+	if uint64(len(z.data)) > 0xffff {
+		return fmt.Errorf("Bstr.n: length %d is too large", len(z.data))
+	}
+	z.n = uint16(len(z.data))
+	return nil
+}
+
 func (z *Mestr) ReadBinary(r io.Reader) (err error) {
-	defer func() { recover() }()
 	if z == nil {
 		return fmt.Errorf("ReadBinary: z nil")
 	}
+	rc := &wire.Counter{R: r}
+	r = rc
+	var field string
+	var off int64
+	defer func() {
+		if err == io.EOF && rc.N != 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil && err != io.EOF {
+			err = wire.Annotate(err, "Mestr", field, off)
+		}
+	}()
+	field, off = "n", rc.N
 
-	if err := binary.Read(r, binary.LittleEndian, &z.n); err != nil {
+	if err := binary.Read(r, binary.LittleEndian, &z.n); err == io.ErrUnexpectedEOF || err == io.EOF && rc.N != 0 {
+		return ioErr("read", int(rc.N-off), binary.Size(z.n))
+	} else if err != nil {
 		return err
 	}
 
-	z.data = make([]byte, int(z.n))
+	field, off = "data", rc.N
 
-	if n, err := r.Read(z.data); err != nil || n != int(z.n) {
-		return err
+	{
+		x := int(z.n)
+		if x < 0 {
+			return fmt.Errorf("negative width %d", x)
+		}
+		if z.data, err = wire.ReadN(r, x); err == io.ErrUnexpectedEOF || err == io.EOF && rc.N != 0 {
+			return ioErr("read", len(z.data), x)
+		} else if err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
 func (z *Mestr) WriteBinary(w io.Writer) (err error) {
-	defer func() { recover() }()
+	if err := z.SetLengths(); err != nil {
+		return err
+	}
 
 	if err := binary.Write(w, binary.LittleEndian, z.n); err != nil {
 		return err
@@ -164,34 +332,105 @@ func (z *Mestr) WriteBinary(w io.Writer) (err error) {
 
 	{
 		x := int(z.n)
-		if n, err := w.Write(z.data[:x]); err != nil || n != x {
+		if x < 0 {
+			return fmt.Errorf("Mestr.data: negative width %d", x)
+		}
+		if len(z.data) < x {
+			return fmt.Errorf("Mestr.data: have %d bytes, want %d", len(z.data), x)
+		}
+		if _, err := w.Write(z.data[:x]); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// MarshalBinary implements encoding.BinaryMarshaler
+func (z Mestr) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := z.WriteBinary(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler. It
+// returns an error if data is not consumed entirely.
+func (z *Mestr) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	if err := z.ReadBinary(r); err != nil {
+		return err
+	}
+	if n := r.Len(); n != 0 {
+		return fmt.Errorf("Mestr: UnmarshalBinary: %d trailing bytes", n)
+	}
+	return nil
+}
+
+// BinarySize returns the length of z's binary encoding
+func (z Mestr) BinarySize() (n int) {
+	// Size the counts as WriteBinary writes them; z is a copy.
+	z.SetLengths()
+	n += 4
+	n += int(z.n)
+	return n
+}
+
+// SetLengths sets the count fields of z from the lengths of the
+// slices they count. WriteBinary calls it before writing.
+func (z *Mestr) SetLengths() error {
+	if uint64(len(z.data)) > 0xffffffff {
+		return fmt.Errorf("Mestr.n: length %d is too large", len(z.data))
+	}
+	z.n = uint32(len(z.data))
+	return nil
+}
+
 func (z *u64s) ReadBinary(r io.Reader) (err error) {
-	defer func() { recover() }()
 	if z == nil {
 		return fmt.Errorf("ReadBinary: z nil")
 	}
+	rc := &wire.Counter{R: r}
+	r = rc
+	var field string
+	var off int64
+	defer func() {
+		if err == io.EOF && rc.N != 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil && err != io.EOF {
+			err = wire.Annotate(err, "u64s", field, off)
+		}
+	}()
+	field, off = "n", rc.N
 
-	if err := binary.Read(r, binary.LittleEndian, &z.n); err != nil {
+	if err := binary.Read(r, binary.LittleEndian, &z.n); err == io.ErrUnexpectedEOF || err == io.EOF && rc.N != 0 {
+		return ioErr("read", int(rc.N-off), binary.Size(z.n))
+	} else if err != nil {
 		return err
 	}
 
-	z.data = make([]byte, int(z.n))
+	field, off = "data", rc.N
 
-	if n, err := r.Read(z.data); err != nil || n != int(z.n) {
-		return err
+	{
+		x := int(z.n)
+		if x < 0 {
+			return fmt.Errorf("negative width %d", x)
+		}
+		if z.data, err = wire.ReadN(r, x); err == io.ErrUnexpectedEOF || err == io.EOF && rc.N != 0 {
+			return ioErr("read", len(z.data), x)
+		} else if err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
 func (z *u64s) WriteBinary(w io.Writer) (err error) {
-	defer func() { recover() }()
+	if err := z.SetLengths(); err != nil {
+		return err
+	}
 
 	if err := binary.Write(w, binary.LittleEndian, z.n); err != nil {
 		return err
@@ -199,34 +438,99 @@ func (z *u64s) WriteBinary(w io.Writer) (err error) {
 
 	{
 		x := int(z.n)
-		if n, err := w.Write(z.data[:x]); err != nil || n != x {
+		if x < 0 {
+			return fmt.Errorf("u64s.data: negative width %d", x)
+		}
+		if len(z.data) < x {
+			return fmt.Errorf("u64s.data: have %d bytes, want %d", len(z.data), x)
+		}
+		if _, err := w.Write(z.data[:x]); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// MarshalBinary implements encoding.BinaryMarshaler
+func (z u64s) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := z.WriteBinary(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler. It
+// returns an error if data is not consumed entirely.
+func (z *u64s) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	if err := z.ReadBinary(r); err != nil {
+		return err
+	}
+	if n := r.Len(); n != 0 {
+		return fmt.Errorf("u64s: UnmarshalBinary: %d trailing bytes", n)
+	}
+	return nil
+}
+
+// BinarySize returns the length of z's binary encoding
+func (z u64s) BinarySize() (n int) {
+	// Size the counts as WriteBinary writes them; z is a copy.
+	z.SetLengths()
+	n += 8
+	n += int(z.n)
+	return n
+}
+
+// SetLengths sets the count fields of z from the lengths of the
+// slices they count. WriteBinary calls it before writing.
+func (z *u64s) SetLengths() error { z.n = uint64(len(z.data)); return nil }
+
 func (z *i64s) ReadBinary(r io.Reader) (err error) {
-	defer func() { recover() }()
 	if z == nil {
 		return fmt.Errorf("ReadBinary: z nil")
 	}
+	rc := &wire.Counter{R: r}
+	r = rc
+	var field string
+	var off int64
+	defer func() {
+		if err == io.EOF && rc.N != 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil && err != io.EOF {
+			err = wire.Annotate(err, "i64s", field, off)
+		}
+	}()
+	field, off = "n", rc.N
 
-	if err := binary.Read(r, binary.LittleEndian, &z.n); err != nil {
+	if err := binary.Read(r, binary.LittleEndian, &z.n); err == io.ErrUnexpectedEOF || err == io.EOF && rc.N != 0 {
+		return ioErr("read", int(rc.N-off), binary.Size(z.n))
+	} else if err != nil {
 		return err
 	}
 
-	z.data = make([]byte, int(z.n))
+	field, off = "data", rc.N
 
-	if n, err := r.Read(z.data); err != nil || n != int(z.n) {
-		return err
+	{
+		x := int(z.n)
+		if x < 0 {
+			return fmt.Errorf("negative width %d", x)
+		}
+		if z.data, err = wire.ReadN(r, x); err == io.ErrUnexpectedEOF || err == io.EOF && rc.N != 0 {
+			return ioErr("read", len(z.data), x)
+		} else if err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
 func (z *i64s) WriteBinary(w io.Writer) (err error) {
-	defer func() { recover() }()
+	if err := z.SetLengths(); err != nil {
+		return err
+	}
 
 	if err := binary.Write(w, binary.LittleEndian, z.n); err != nil {
 		return err
@@ -234,34 +538,99 @@ func (z *i64s) WriteBinary(w io.Writer) (err error) {
 
 	{
 		x := int(z.n)
-		if n, err := w.Write(z.data[:x]); err != nil || n != x {
+		if x < 0 {
+			return fmt.Errorf("i64s.data: negative width %d", x)
+		}
+		if len(z.data) < x {
+			return fmt.Errorf("i64s.data: have %d bytes, want %d", len(z.data), x)
+		}
+		if _, err := w.Write(z.data[:x]); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// MarshalBinary implements encoding.BinaryMarshaler
+func (z i64s) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := z.WriteBinary(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler. It
+// returns an error if data is not consumed entirely.
+func (z *i64s) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	if err := z.ReadBinary(r); err != nil {
+		return err
+	}
+	if n := r.Len(); n != 0 {
+		return fmt.Errorf("i64s: UnmarshalBinary: %d trailing bytes", n)
+	}
+	return nil
+}
+
+// BinarySize returns the length of z's binary encoding
+func (z i64s) BinarySize() (n int) {
+	// Size the counts as WriteBinary writes them; z is a copy.
+	z.SetLengths()
+	n += 8
+	n += int(z.n)
+	return n
+}
+
+// SetLengths sets the count fields of z from the lengths of the
+// slices they count. WriteBinary calls it before writing.
+func (z *i64s) SetLengths() error { z.n = int64(len(z.data)); return nil }
+
 func (z *BBEStr) ReadBinary(r io.Reader) (err error) {
-	defer func() { recover() }()
 	if z == nil {
 		return fmt.Errorf("ReadBinary: z nil")
 	}
+	rc := &wire.Counter{R: r}
+	r = rc
+	var field string
+	var off int64
+	defer func() {
+		if err == io.EOF && rc.N != 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil && err != io.EOF {
+			err = wire.Annotate(err, "BBEStr", field, off)
+		}
+	}()
+	field, off = "n", rc.N
 
-	if err := binary.Read(r, binary.LittleEndian, &z.n); err != nil {
+	if err := binary.Read(r, binary.LittleEndian, &z.n); err == io.ErrUnexpectedEOF || err == io.EOF && rc.N != 0 {
+		return ioErr("read", int(rc.N-off), binary.Size(z.n))
+	} else if err != nil {
 		return err
 	}
 
-	z.data = make([]byte, int(z.n))
+	field, off = "data", rc.N
 
-	if n, err := r.Read(z.data); err != nil || n != int(z.n) {
-		return err
+	{
+		x := int(z.n)
+		if x < 0 {
+			return fmt.Errorf("negative width %d", x)
+		}
+		if z.data, err = wire.ReadN(r, x); err == io.ErrUnexpectedEOF || err == io.EOF && rc.N != 0 {
+			return ioErr("read", len(z.data), x)
+		} else if err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
 func (z *BBEStr) WriteBinary(w io.Writer) (err error) {
-	defer func() { recover() }()
+	if err := z.SetLengths(); err != nil {
+		return err
+	}
 
 	if err := binary.Write(w, binary.LittleEndian, z.n); err != nil {
 		return err
@@ -269,28 +638,93 @@ func (z *BBEStr) WriteBinary(w io.Writer) (err error) {
 
 	{
 		x := int(z.n)
-		if n, err := w.Write(z.data[:x]); err != nil || n != x {
+		if x < 0 {
+			return fmt.Errorf("BBEStr.data: negative width %d", x)
+		}
+		if len(z.data) < x {
+			return fmt.Errorf("BBEStr.data: have %d bytes, want %d", len(z.data), x)
+		}
+		if _, err := w.Write(z.data[:x]); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// MarshalBinary implements encoding.BinaryMarshaler
+func (z BBEStr) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := z.WriteBinary(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler. It
+// returns an error if data is not consumed entirely.
+func (z *BBEStr) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	if err := z.ReadBinary(r); err != nil {
+		return err
+	}
+	if n := r.Len(); n != 0 {
+		return fmt.Errorf("BBEStr: UnmarshalBinary: %d trailing bytes", n)
+	}
+	return nil
+}
+
+// BinarySize returns the length of z's binary encoding
+func (z BBEStr) BinarySize() (n int) {
+	// Size the counts as WriteBinary writes them; z is a copy.
+	z.SetLengths()
+	n += 8
+	n += int(z.n)
+	return n
+}
+
+// SetLengths sets the count fields of z from the lengths of the
+// slices they count. WriteBinary calls it before writing.
+func (z *BBEStr) SetLengths() error { z.n = int64(len(z.data)); return nil }
+
 func (z *ApeStr) ReadBinary(r io.Reader) (err error) {
-	defer func() { recover() }()
 	if z == nil {
 		return fmt.Errorf("ReadBinary: z nil")
 	}
+	rc := &wire.Counter{R: r}
+	r = rc
+	var field string
+	var off int64
+	defer func() {
+		if err == io.EOF && rc.N != 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil && err != io.EOF {
+			err = wire.Annotate(err, "ApeStr", field, off)
+		}
+	}()
+	field, off = "n", rc.N
 
-	if err := binary.Read(r, binary.LittleEndian, &z.n); err != nil {
+	if err := binary.Read(r, binary.BigEndian, &z.n); err == io.ErrUnexpectedEOF || err == io.EOF && rc.N != 0 {
+		return ioErr("read", int(rc.N-off), binary.Size(z.n))
+	} else if err != nil {
 		return err
 	}
 
-	z.data = make([]Pstr, int(z.n))
-	for i := 0; i < int(z.n); i++ {
+	field, off = "data", rc.N
 
-		if err := z.data[i].ReadBinary(r); err != nil {
-			return err
+	{
+		x := int(z.n)
+		if x < 0 {
+			return fmt.Errorf("negative width %d", x)
+		}
+		z.data = nil
+		for i := 0; i < x; i++ {
+			off = rc.N
+			var v Pstr
+			if err := v.ReadBinary(r); err != nil {
+				return err
+			}
+			z.data = append(z.data, v)
 		}
 	}
 
@@ -298,18 +732,67 @@ func (z *ApeStr) ReadBinary(r io.Reader) (err error) {
 }
 
 func (z *ApeStr) WriteBinary(w io.Writer) (err error) {
-	defer func() { recover() }()
+	if err := z.SetLengths(); err != nil {
+		return err
+	}
 
-	if err := binary.Write(w, binary.LittleEndian, z.n); err != nil {
+	if err := binary.Write(w, binary.BigEndian, z.n); err != nil {
 		return err
 	}
 
-	z.data = make([]Pstr, int(z.n))
-	for i := 0; i < int(z.n); i++ {
-		if err := z.data[i].WriteBinary(w); err != nil {
-			return err
+	{
+		x := int(z.n)
+		if len(z.data) < x {
+			return fmt.Errorf("ApeStr.data: have %d elements, want %d", len(z.data), x)
+		}
+		for i := 0; i < x; i++ {
+			if err := z.data[i].WriteBinary(w); err != nil {
+				return err
+			}
 		}
 	}
+	return nil
+}
 
+// MarshalBinary implements encoding.BinaryMarshaler
+func (z ApeStr) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := z.WriteBinary(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler. It
+// returns an error if data is not consumed entirely.
+func (z *ApeStr) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	if err := z.ReadBinary(r); err != nil {
+		return err
+	}
+	if n := r.Len(); n != 0 {
+		return fmt.Errorf("ApeStr: UnmarshalBinary: %d trailing bytes", n)
+	}
+	return nil
+}
+
+// BinarySize returns the length of z's binary encoding
+func (z ApeStr) BinarySize() (n int) {
+	// Size the counts as WriteBinary writes them; z is a copy.
+	z.SetLengths()
+	n += 2
+	for i, x := 0, int(z.n); i < x && i < len(z.data); i++ {
+		n += z.data[i].BinarySize()
+	}
+	return n
+}
+
+// SetLengths sets the count fields of z from the lengths of the
+// slices they count. WriteBinary calls it before writing.
+func (z *ApeStr) SetLengths() error {
+	if uint64(len(z.data)) > 0xffff {
+		return fmt.Errorf("ApeStr.n: length %d is too large", len(z.data))
+	}
+	z.n = uint16(len(z.data))
 	return nil
 }
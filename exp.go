@@ -9,6 +9,7 @@ import (
 	"go/format"
 	goparser "go/parser"
 	"go/printer"
+	"go/scanner"
 	"go/token"
 	"go/types"
 	"io"
@@ -31,6 +32,8 @@ const (
 	WidthLit WidthFlag = 1 << iota
 	WidthVar
 	WidthBad
+	WidthBit
+	WidthRest
 )
 
 // On returns true is bits are set
@@ -57,6 +60,14 @@ type Source struct {
 	p       *parser
 	Structs []*ast.TypeSpec
 	DupMap
+
+	// Endian is the default byte order set by the last
+	// //wire9:endian directive in the current file
+	Endian binary.ByteOrder
+
+	// BitOrder is the default bit order set by the last
+	// //wire9:bitorder directive in the current file
+	BitOrder BitOrder
 }
 
 // File struct. TODO: Revise
@@ -79,6 +90,22 @@ type Info struct {
 	Endian       binary.ByteOrder
 	Flag         WidthFlag
 	FromGoSource bool
+
+	// Bit fields
+	Bits     int      // width in bits, or 0 if not a bit field
+	Shift    int      // offset of the field's low bit within its group
+	Group    int      // bytes in the group; set on the first field only
+	BitOrder BitOrder // packing order of the group
+
+	Union *Union   // type selected by a tag field; nil if not a union
+	Cond  ast.Expr // presence condition; nil if always present
+	Rest  bool     // field holds the rest of the input
+	Frame bool     // field holds the length of the whole message
+	Max   int      // maximum number of elements; 0 if unset
+
+	Const *ast.BasicLit // value of a constant field; nil if not constant
+	Pad   bool          // field is padding, and has no struct field
+	Align int           // padding aligns the message to Align bytes; 0 if not
 }
 
 // OpenPackage opens the package at path. It returns a partialy-initialized Package
@@ -113,40 +140,22 @@ func OpenPackage(path string, dowires bool) (pkg *Package, err error) {
 // FromFiles produces wire9 structures and functions by reading wire
 // definitions from files. Dofmt controls gofmt operation.
 func FromFiles(files []string, dups DupMap, dofmt bool) ([]byte, error) {
-	const Banner = `
-	package main
-	
-	import (
-		"encoding/binary"
-		"bytes"
-		"io"
-		"fmt"
-	)
-	`
-	var buf bytes.Buffer
-	fmt.Fprint(&buf, Banner)
-
-	src, err := ParseFiles(files, dups)
-	if err != nil {
-		return nil, err
-	}
-	if err = src.Generate(&buf); err != nil {
-		return nil, err
-	}
-	data := Clean(buf.Bytes())
-	if !dofmt {
-		data, err = format.Source(data)
+	fset := token.NewFileSet()
+	var astfiles []*ast.File
+	for _, name := range files {
+		f, err := goparser.ParseFile(fset, name, nil, goparser.ImportsOnly)
 		if err != nil {
-			return nil, fmt.Errorf("gofmt: %s", err)
+			return nil, err
 		}
+		astfiles = append(astfiles, f)
 	}
-	return data, nil
+	return generate(files, astfiles, dups, dofmt)
 }
 
 // FromPackage produces wire9 structures and functions via from a Package
 // opened with OpenPackage.
 func FromPackage(pkg *Package, dofmt bool) (wire *Package, err error) {
-	data, err := FromFiles(pkg.Files, pkg.DupMap, dofmt)
+	data, err := generate(pkg.Files, pkg.ASTFiles, pkg.DupMap, dofmt)
 	if err != nil {
 		return nil, err
 	}
@@ -165,6 +174,41 @@ func FromPackage(pkg *Package, dofmt bool) (wire *Package, err error) {
 	return wire, nil
 }
 
+// generate produces the wire9 source for the definitions in files. The
+// package clause and import paths are taken from astfiles.
+func generate(files []string, astfiles []*ast.File, dups DupMap, dofmt bool) ([]byte, error) {
+	src, err := ParseFiles(files, dups)
+	if err != nil {
+		return nil, err
+	}
+	var body bytes.Buffer
+	if err = src.Generate(&body); err != nil {
+		return nil, err
+	}
+	imports, err := Imports(body.Bytes(), astfiles, src.TypeRefs())
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "package %s\n", PackageName(astfiles))
+	if len(imports) > 0 {
+		fmt.Fprintln(&buf, "import (")
+		for _, im := range imports {
+			fmt.Fprintf(&buf, "\t%s\n", im)
+		}
+		fmt.Fprintln(&buf, ")")
+	}
+	buf.Write(body.Bytes())
+	data := Clean(buf.Bytes())
+	if dofmt {
+		data, err = format.Source(data)
+		if err != nil {
+			return nil, fmt.Errorf("gofmt: %s", err)
+		}
+	}
+	return data, nil
+}
+
 // Eval parses and processes a line of input as a wire definition. The
 // definition must start with a double slash and follow the format
 // given in the package description comment.
@@ -174,6 +218,18 @@ func (src *Source) Eval(line string, dups DupMap) (st *ast.TypeSpec, err error)
 	if src.p, err = NewParser(src.fs, line, dups); err != nil {
 		return
 	}
+	defer func() {
+		// The parser panics on its first syntax error
+		switch e := recover().(type) {
+		case nil:
+		case scanner.ErrorList, bailout:
+			st, err = nil, src.p.errors.Err()
+		default:
+			panic(e)
+		}
+	}()
+	src.p.endian = src.Endian
+	src.p.bitorder = src.BitOrder
 	x := src.p.parseDefinition()
 	return x, src.p.errors.Err()
 }
@@ -189,11 +245,18 @@ func (src *Source) Parse(name string) ([]*ast.TypeSpec, error) {
 	file := &File{
 		Name: name,
 	}
+	src.Endian, src.BitOrder = nil, MSBFirst
 	for s.Scan() {
 		t := s.Text()
 		if !strings.HasPrefix(t, "//wire9") {
 			continue
 		}
+		if strings.HasPrefix(t, "//wire9:") {
+			if err := src.Directive(t); err != nil {
+				return nil, fmt.Errorf("%s: %s", name, err)
+			}
+			continue
+		}
 		list, err := src.Eval(t, src.DupMap)
 		if err != nil {
 			return nil, err
@@ -204,6 +267,42 @@ func (src *Source) Parse(name string) ([]*ast.TypeSpec, error) {
 	return src.Structs, err
 }
 
+// Directive processes a line of the form
+//
+//	//wire9:name arg ...
+//
+// The endian and bitorder directives set the default byte and bit order
+// for the definitions following them in the same file.
+func (src *Source) Directive(line string) error {
+	args := strings.Fields(strings.TrimPrefix(line, "//wire9:"))
+	if len(args) == 0 {
+		return fmt.Errorf("empty directive: %q", line)
+	}
+	switch args[0] {
+	case "endian":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: //wire9:endian LE|BE")
+		}
+		e := EndianOf(args[1])
+		if e == nil {
+			return fmt.Errorf(`endian must be "LE" or "BE", got %q`, args[1])
+		}
+		src.Endian = e
+		return nil
+	case "bitorder":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: //wire9:bitorder MSB|LSB")
+		}
+		o, ok := BitOrderOf(args[1])
+		if !ok {
+			return fmt.Errorf(`bit order must be "MSB" or "LSB" got %s`, args[1])
+		}
+		src.BitOrder = o
+		return nil
+	}
+	return fmt.Errorf("unknown directive: %s", args[0])
+}
+
 // NewTypeInfo returns an initialized *TypeInfo
 func NewTypeInfo() *TypeInfo {
 	return &TypeInfo{
@@ -251,6 +350,9 @@ func (t *TypeInfo) NamedStruct(n string) ast.TypeSpec {
 
 // ParseLine parses and processes a line of input as a wire definition. TODO: Consolidate
 func (src *Source) ParseLine(line string) (*ast.TypeSpec, error) {
+	if strings.HasPrefix(line, "//wire9:") {
+		return nil, src.Directive(strings.TrimSpace(line))
+	}
 	st, err := src.Eval(line, src.DupMap)
 	if err != nil {
 		return nil, err
@@ -312,8 +414,10 @@ func Clean(src []byte) []byte {
 	return b.Bytes()
 }
 
-// Generate outputs source file from a source set src.
+// Generate outputs source file from a source set src. Helper functions
+// are written once per call.
 func (src *Source) Generate(w io.Writer) error {
+	Flags = map[string]bool{}
 	for _, t := range src.Files {
 		if err := src.p.genTypes(w, t.Structs...); err != nil {
 			return err
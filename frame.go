@@ -0,0 +1,127 @@
+package wire9
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+)
+
+// frameOf returns the frame size field of ts, or nil if ts is not a
+// frame. The second value is the offset of the field in the frame,
+// which requires the fields preceding it to have a constant width.
+func frameOf(ts *ast.TypeSpec) (*ast.Field, int, error) {
+	fields := ts.Type.(*ast.StructType).Fields.List
+	for i, f := range fields {
+		if info := TInfo.Get(ts, f); info == nil || !info.Frame {
+			continue
+		}
+		off := 0
+		for _, g := range fields[:i] {
+			n, ok := fieldSize(ts, g, make(map[string]bool))
+			if info := TInfo.Get(ts, g); !ok || info.Cond != nil {
+				return nil, 0, fmt.Errorf("%s.%s: fields before a frame size must have a constant width",
+					ts.Name.Name, f.Names[0].Name)
+			}
+			off += n
+		}
+		return f, off, nil
+	}
+	return nil, 0, nil
+}
+
+// frameHeader returns the frame size field of ts and the number of
+// bytes up to and including it.
+func frameHeader(ts *ast.TypeSpec) (f *ast.Field, off, hdr int, err error) {
+	f, off, err = frameOf(ts)
+	if f == nil || err != nil {
+		return nil, 0, 0, err
+	}
+	n, err := NumSize(f)
+	return f, off, off + n, err
+}
+
+// FrameField returns statements limiting the rest of the decoding to the
+// frame if f is the frame size field of ts. Method is one of Read or
+// Decode.
+func FrameField(ts *ast.TypeSpec, f *ast.Field, method string) (string, error) {
+	size, _, hdr, err := frameHeader(ts)
+	if size != f || err != nil {
+		return "", err
+	}
+	b := new(bytes.Buffer)
+	fn := f.Names[0].Name
+	switch method {
+	case "Read":
+		fmt.Fprintf(b, "if int64(z.%s) < %d {\n", fn, hdr)
+		fmt.Fprintf(b, "return fmt.Errorf(\"frame size %%d is less than %d\", z.%s)\n}\n", hdr, fn)
+		fmt.Fprintf(b, "frame := &io.LimitedReader{R: r, N: int64(z.%s) - %d}\nr = frame\n", fn, hdr)
+	case "Decode":
+		fmt.Fprintf(b, "if int64(z.%s) < %d {\n", fn, hdr)
+		fmt.Fprintf(b, "return n, fmt.Errorf(\"frame size %%d is less than %d\", z.%s)\n}\n", hdr, fn)
+		fmt.Fprintf(b, "if uint64(len(b)) < uint64(z.%s) {\nreturn n, ioErr(\"frame\", len(b), int(z.%s))\n}\n", fn, fn)
+		fmt.Fprintf(b, "b = b[:z.%s]\n", fn)
+	}
+	return b.String(), nil
+}
+
+// FrameStart returns statements preparing to back-fill the frame size
+// of ts. Method is one of Write or Append.
+func FrameStart(ts *ast.TypeSpec, method string) (string, error) {
+	size, _, _, err := frameHeader(ts)
+	if size == nil || err != nil {
+		return "", err
+	}
+	switch method {
+	case "Write":
+		return "out, frame := w, new(bytes.Buffer)\nw = frame\n", nil
+	case "Append":
+		return "start := len(b)\n", nil
+	}
+	return "", nil
+}
+
+// FrameEnd returns statements completing the frame of ts. On Read and
+// Decode, they verify the frame was consumed entirely, reporting any
+// error at the frame size field. On Write and Append, they back-fill the
+// frame size.
+func FrameEnd(ts *ast.TypeSpec, method string) (string, error) {
+	size, off, _, err := frameHeader(ts)
+	if size == nil || err != nil {
+		return "", err
+	}
+	b := new(bytes.Buffer)
+	switch method {
+	case "Read":
+		fmt.Fprintf(b, "field, off = %q, %d\n", size.Names[0].Name, off)
+		fmt.Fprintf(b, "if frame.N != 0 {\nreturn fmt.Errorf(\"%%d unread bytes in frame\", frame.N)\n}\n")
+	case "Decode":
+		fmt.Fprintf(b, "field, off = %q, %d\n", size.Names[0].Name, off)
+		fmt.Fprintf(b, "if n != len(b) {\nreturn n, fmt.Errorf(\"%%d unread bytes in frame\", len(b)-n)\n}\n")
+	case "Write":
+		fmt.Fprintf(b, "{\nbuf := frame.Bytes()\n")
+		fillFrame(b, ts, size, off, "")
+		fmt.Fprintf(b, "if _, err := out.Write(buf); err != nil {\nreturn err\n}\n}\n")
+	case "Append":
+		fmt.Fprintf(b, "{\nbuf := b[start:]\n")
+		fillFrame(b, ts, size, off, "b, ")
+		fmt.Fprintf(b, "}\n")
+	}
+	return b.String(), nil
+}
+
+// fillFrame writes statements storing len(buf) in the frame size field
+// and at buf[off:]. ret is prepended to the error result.
+func fillFrame(b *bytes.Buffer, ts *ast.TypeSpec, f *ast.Field, off int, ret string) {
+	typ, fn := TypeString(f.Type), f.Names[0].Name
+	n, _ := NumSize(f)
+	if max, ok := maxCount(ts, f); ok {
+		fmt.Fprintf(b, "if uint64(len(buf)) > %#x {\n", max)
+		fmt.Fprintf(b, "return %sfmt.Errorf(\"%s.%s: frame of %%d bytes is too long\", len(buf))\n}\n", ret, ts.Name.Name, fn)
+	}
+	fmt.Fprintf(b, "z.%s = %s(len(buf))\n", fn, typ)
+	if n == 1 {
+		fmt.Fprintf(b, "buf[%d] = byte(z.%s)\n", off, fn)
+		return
+	}
+	fmt.Fprintf(b, "%s.PutUint%d(buf[%d:], uint%d(z.%s))\n", Endian(ts, f), 8*n, off, 8*n, fn)
+}
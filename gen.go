@@ -11,12 +11,37 @@ import (
 
 var (
 	eStruct      = template.Must(template.New("tStruct").Funcs(funcMap).Parse(tStruct))
+	eUnion       = template.Must(template.New("tUnion").Funcs(funcMap).Parse(tUnion))
 	eWriteBinary = template.Must(template.New("tWriteBinary").Funcs(funcMap).Parse(tWriteBinary))
 	eReadBinary  = template.Must(template.New("tReadBinary").Funcs(funcMap).Parse(tReadBinary))
+
+	eMarshalBinary   = template.Must(template.New("tMarshalBinary").Funcs(funcMap).Parse(tMarshalBinary))
+	eUnmarshalBinary = template.Must(template.New("tUnmarshalBinary").Funcs(funcMap).Parse(tUnmarshalBinary))
+	eBinarySize      = template.Must(template.New("tBinarySize").Funcs(funcMap).Parse(tBinarySize))
+	eDecodeFrom      = template.Must(template.New("tDecodeFrom").Funcs(funcMap).Parse(tDecodeFrom))
+	eAppendBinary    = template.Must(template.New("tAppendBinary").Funcs(funcMap).Parse(tAppendBinary))
+	eSetLengths      = template.Must(template.New("tSetLengths").Funcs(funcMap).Parse(tSetLengths))
 )
 
 var Flags = map[string]bool{}
 
+// Options control optional output of the generator.
+var Options struct {
+	// SliceCodec enables the DecodeFrom and AppendBinary methods. They
+	// operate on byte slices directly instead of through an io.Reader or
+	// io.Writer.
+	SliceCodec bool
+
+	// MaxLen is the maximum number of elements in a slice whose width is
+	// read from the input, or that holds the rest of it, unless the field
+	// sets its own with a max option. Zero means no limit.
+	MaxLen int
+
+	// Arrays makes fields with a literal width over 8 bytes and no type
+	// byte arrays instead of byte slices.
+	Arrays bool
+}
+
 func WasSet(s string) bool {
 	_, ok := Flags[s]
 	Flags[s] = true
@@ -36,32 +61,68 @@ func (p *parser) genTypes(w io.Writer, exprs ...*ast.TypeSpec) (err error) {
 		if err = eStruct.Execute(w, e); err != nil {
 			return
 		}
+		for _, f := range e.Type.(*ast.StructType).Fields.List {
+			if !IsUnion(e, f) {
+				continue
+			}
+			u := unionType{
+				Name:       TypeString(f.Type),
+				Struct:     e.Name.Name,
+				Field:      f.Names[0].Name,
+				SliceCodec: Options.SliceCodec,
+			}
+			if err = eUnion.Execute(w, u); err != nil {
+				return
+			}
+		}
 	}
 	return nil
 }
 
 func (p *parser) genFuncs(w io.Writer, exprs ...*ast.TypeSpec) (err error) {
 	for _, e := range exprs {
-		if id := p.DupMap[Dup{e.Name.Name, "ReadBinary"}]; id != nil {
-			log.Printf("gen: skip already-defined ReadBinary method for: %s\n", id.Name)
-			fmt.Fprintf(w, "// func (z %s) ReadBinary { // defined in other file}\n", id.Name)
-		} else {
-			if err = eReadBinary.Execute(w, e); err != nil {
-				return
-			}
+		if err = p.genFunc(w, e, "ReadBinary", eReadBinary); err != nil {
+			return
 		}
-		if id := p.DupMap[Dup{e.Name.Name, "WriteBinary"}]; id != nil {
-			log.Printf("gen: skip already-defined WriteBinary method for: %s\n", id.Name)
-			fmt.Fprintf(w, "// func (z %s) WriteBinary { //  defined in other file\n", id.Name)
-		} else {
-			if err = eWriteBinary.Execute(w, e); err != nil {
-				return
-			}
+		if err = p.genFunc(w, e, "WriteBinary", eWriteBinary); err != nil {
+			return
+		}
+		if err = p.genFunc(w, e, "MarshalBinary", eMarshalBinary); err != nil {
+			return
+		}
+		if err = p.genFunc(w, e, "UnmarshalBinary", eUnmarshalBinary); err != nil {
+			return
+		}
+		if err = p.genFunc(w, e, "BinarySize", eBinarySize); err != nil {
+			return
+		}
+		if err = p.genFunc(w, e, "SetLengths", eSetLengths); err != nil {
+			return
+		}
+		if !Options.SliceCodec {
+			continue
+		}
+		if err = p.genFunc(w, e, "DecodeFrom", eDecodeFrom); err != nil {
+			return
+		}
+		if err = p.genFunc(w, e, "AppendBinary", eAppendBinary); err != nil {
+			return
 		}
 	}
 	return nil
 }
 
+// genFunc executes the method template t for e unless the method
+// is already defined in another file of the package.
+func (p *parser) genFunc(w io.Writer, e *ast.TypeSpec, method string, t *template.Template) error {
+	if id := p.DupMap[Dup{e.Name.Name, method}]; id != nil {
+		log.Printf("gen: skip already-defined %s method for: %s\n", method, id.Name)
+		fmt.Fprintf(w, "// func (z %s) %s { // defined in other file\n", id.Name, method)
+		return nil
+	}
+	return t.Execute(w, e)
+}
+
 //
 // Template functions
 
@@ -73,6 +134,7 @@ var funcMap = template.FuncMap{
 	"coherent":     Coherent,
 	"custom":       Custom,
 	"customslice":  CustomSlice,
+	"customarray":  CustomArray,
 	"endian":       Endian,
 	"numeric":      Numeric,
 	"slice":        Slice,
@@ -88,8 +150,44 @@ var funcMap = template.FuncMap{
 	"wired":        func(f ast.Expr) bool { return !Slice(f) && !Array(f) },
 	"binary":       func(f ast.Expr) bool { return Numeric(f) },
 	"width":        WidthOf,
+	"numsize":      NumSize,
+	"bitfield":     BitField,
+	"readbits":     ReadBits,
+	"writebits":    WriteBits,
+	"decodebits":   DecodeBits,
+	"appendbits":   AppendBits,
+	"union":        IsUnion,
+	"readunion":    ReadUnion,
+	"decodeunion":  DecodeUnion,
+	"settags":      SetTags,
+	"condopen":     CondOpen,
+	"condclose":    CondClose,
+	"rest":         Rest,
+	"varwidth":     VarWidth,
+	"maxlen":       MaxLen,
+	"isconst":      IsConst,
+	"constfield":   ConstField,
+	"ispad":        IsPad,
+	"padfield":     PadField,
+	"narrow":       Narrow,
+	"narrowfield":  NarrowField,
+	"numslice":     NumSlice,
+	"numsfield":    NumSliceField,
+	"alignstart":   AlignStart,
+	"elem":         func(f ast.Expr) string { return TypeString(f.(*ast.ArrayType).Elt) },
+	"framefield":   FrameField,
+	"framestart":   FrameStart,
+	"frameend":     FrameEnd,
+	"haslengths":   HasLengths,
+	"setlengths":   SetLengths,
+	"sizeof":       SizeOf,
+	"staticsize":   StaticSize,
+	"decodenum":    DecodeNum,
+	"appendnum":    AppendNum,
 	"literal":      Literal,
+	"nilcheck":     NilCheck,
 	"declaredname": func(f *ast.Field) string { return f.Names[0].Name },
+	"fieldname":    FieldName,
 	"name": func(f *ast.Field) (n string) {
 		n = f.Names[0].Name
 		if Nesting > 0 {
@@ -121,8 +219,8 @@ const tWriteString = `
 		return err
 	}
 	
-	func ioErr(name, kind string, ac, ex int) error{
-		return fmt.Errorf("%s: short %s: %d/%d", name, kind, ac, ex)
+	func ioErr(kind string, ac, ex int) error {
+		return fmt.Errorf("short %s: %d/%d bytes: %w", kind, ac, ex, io.ErrUnexpectedEOF)
 	}
 `
 
@@ -132,43 +230,146 @@ const tStruct = `
 {{ with $fl :=  $s | fields}}
 type {{ $nm }} struct{
 	{{- range $i, $v := $fl -}}
-		{{- if $v.Type | literal }}
-			{{ "todo" |  printf "// %s" }}{{- else -}}
+		{{- if not (or (isconst $s $v) (ispad $s $v)) }}
 			{{$v | name }} {{ $v.Type | typeof }}
 		{{- end }}
-	{{ end}}
+	{{- end}}
 {{- end}}{{- end}}{{- end}}
 }
 `
+const tUnion = `
+	// {{.Name}} is implemented by the types {{.Struct}}.{{.Field}} can hold.
+	// The value of {{.Field}} is a pointer to one of the types in its switch.
+	type {{.Name}} interface {
+		ReadBinary(io.Reader) error
+		WriteBinary(io.Writer) error
+		BinarySize() int
+		{{- if .SliceCodec }}
+		DecodeFrom([]byte) (int, error)
+		AppendBinary([]byte) ([]byte, error)
+		{{- end }}
+	}
+`
 const tReadBinary = `
 {{ with $st := . }}
 {{ with $nm := .Name | printf "%s" }}
 	func (z *{{$nm}}) ReadBinary(r io.Reader) (err error) {
-		defer func() { recover() }()
-		if z == nil {return fmt.Errorf("ReadBinary: z nil") };
+		if z == nil {
+			return fmt.Errorf("ReadBinary: z nil")
+		}
+		rc := &wire.Counter{R: r}
+		r = rc
+		var field string
+		var off int64
+		defer func() {
+			if err == io.EOF && rc.N != 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			if err != nil && err != io.EOF {
+				err = wire.Annotate(err, "{{$nm}}", field, off)
+			}
+		}()
 		{{- range $i, $f := $st | fields}}
-			{{with $nm  := $f | name}}{{with $typ := $f | typeof }}
-				{
-				{{- if $f.Type | looped }}
-                	z.{{$f | declaredname }} = make({{$typ}}, {{ ((width $st $f)) }})
-					for i := 0; i < {{ ((width $st $f)) }}; i++ {
-                {{else}}
-					{{ if $f.Type | alloc    }}  z.{{$f | name}} = make([]byte, {{ ((width $st $f)) }}) {{else}}
-					{{ if $f.Type | initial  }}    {{$f | name}} = {{$f | typeof}}{} {{else}}
-					{{ if $f.Type | maketmp  }}  tmp := make([]byte, {{ ((width $st $f)) }}) {{end}}{{end}}{{end}}
-				{{end}}
-
-				{{ if $f.Type | normal	}}      if n, err := r.Read(z.{{$f | name}});       err != nil || n != {{ ((width $st $f)) }}  {return err}{{else}}
-				{{ if $f.Type | customslice }} if    err := z.{{$f | name}}.ReadBinary(r); err != nil { return err } {{else}}
-				{{ if $f.Type | binary	}}      if err :=    binary.Read(r, {{$f | endian }}, &z.{{$f | name}}); err != nil { return err } {{else}}
-				{{ if $f.Type | literal	}}  if n, err := r.Read(tmp); err != nil || bytes.Compare(tmp, []byte({{$f | name}})) != 0 {
-						if err != nil { return return fmt.Errorf("z.%x: read %x instead", []byte({{$f | name}}, tmp)};
-						return err;
-					}{{else}}
-				{{ if $f.Type | wired }} if    err := z.{{$f | name}}.ReadBinary(r); err != nil { return err } ;{{- end}}{{- end}}{{- end}}{{- end}}{{- end}}
-			{{- if $f.Type | unlooped }} } {{end}}{{end}}
-		{{- end}}
-				}{{end}}
+			{{- with $fn := $f | declaredname }}
+			field, off = "{{ fieldname $st $f }}", rc.N
+			{{ condopen $st $f }}
+			{
+			{{- if isconst $st $f }}
+				{{ constfield $st $f "Read" }}
+			{{- else if bitfield $st $f }}
+				{{ readbits $st $f }}
+			{{- else if ispad $st $f }}
+				{{ padfield $st $f "Read" }}
+			{{- else if union $st $f }}
+				{{ readunion $st $f }}
+			{{- else if rest $st $f }}
+				{{- with maxlen $st $f }}
+				if z.{{$fn}}, err = io.ReadAll(io.LimitReader(r, {{.}}+1)); err != nil {
+					return err
+				}
+				if len(z.{{$fn}}) > {{.}} {
+					return &wire.LengthError{Len: len(z.{{$fn}}), Max: {{.}}}
+				}
+				{{- else }}
+				if z.{{$fn}}, err = io.ReadAll(r); err != nil {
+					return err
+				}
+				{{- end }}
+			{{- else if $f.Type | numslice }}
+				{{ numsfield $st $f "Read" }}
+			{{- else if $f.Type | customslice }}
+				x := {{ width $st $f }}
+				{{- if varwidth $st $f }}
+				if x < 0 {
+					return fmt.Errorf("negative width %d", x)
+				}
+				{{- end }}
+				{{- with maxlen $st $f }}
+				if x > {{.}} {
+					return &wire.LengthError{Len: x, Max: {{.}}}
+				}
+				{{- end }}
+				z.{{$fn}} = nil
+				for i := 0; i < x; i++ {
+					off = rc.N
+					var v {{$f.Type | elem}}
+					{{- with nilcheck $st $f "v" "Read" }}
+					{{.}}
+					{{- end }}
+					if err := v.ReadBinary(r); err != nil {
+						return err
+					}
+					z.{{$fn}} = append(z.{{$fn}}, v)
+				}
+			{{- else if $f.Type | customarray }}
+				for i := range z.{{$fn}} {
+					off = rc.N
+					{{- with nilcheck $st $f (printf "z.%s[i]" $fn) "Read" }}
+					{{.}}
+					{{- end }}
+					if err := z.{{$fn}}[i].ReadBinary(r); err != nil {
+						return err
+					}
+				}
+			{{- else if $f.Type | normal }}
+				x := {{ width $st $f }}
+				{{- if varwidth $st $f }}
+				if x < 0 {
+					return fmt.Errorf("negative width %d", x)
+				}
+				{{- end }}
+				{{- with maxlen $st $f }}
+				if x > {{.}} {
+					return &wire.LengthError{Len: x, Max: {{.}}}
+				}
+				{{- end }}
+				if z.{{$fn}}, err = wire.ReadN(r, x); err == io.ErrUnexpectedEOF || err == io.EOF && rc.N != 0 {
+					return ioErr("read", len(z.{{$fn}}), x)
+				} else if err != nil {
+					return err
+				}
+			{{- else if narrow $st $f }}
+				{{ narrowfield $st $f "Read" }}
+			{{- else if $f.Type | binary }}
+				if err := binary.Read(r, {{endian $st $f}}, &z.{{$fn}}); err == io.ErrUnexpectedEOF || err == io.EOF && rc.N != 0 {
+					return ioErr("read", int(rc.N-off), binary.Size(z.{{$fn}}))
+				} else if err != nil {
+					return err
+				}
+			{{- else if $f.Type | wired }}
+				{{- with nilcheck $st $f (printf "z.%s" $fn) "Read" }}
+				{{.}}
+				{{- end }}
+				if err := z.{{$fn}}.ReadBinary(r); err != nil {
+					return err
+				}
+			{{- else }}{{ call bailout }}{{- end }}
+			}
+			{{- condclose $st $f }}
+			{{ framefield $st $f "Read" -}}
+			{{- end }}
+		{{- end }}
+		{{ frameend $st "Read" -}}
 		return nil
 	}
 {{end}}
@@ -178,59 +379,370 @@ const tWriteBinary = `
 {{ with $st := . }}
 {{ with $nm := .Name | printf "%s" }}
 	func (z *{{$nm}}) WriteBinary(w io.Writer) (err error) {
-		defer func() { recover() }()
+		{{- if haslengths $st }}
+		if err := z.SetLengths(); err != nil {
+			return err
+		}
+		{{- end }}
+		{{ settags $st "" }}
+		{{ framestart $st "Write" -}}
+		{{ alignstart $st "Write" -}}
 		{{- range $i, $f := $st | fields}}
-			{{with $nm  := $f | name}}{{with $typ := $f | typeof }}
-				{
-				{{- if $f.Type | looped }}
-                  z.{{$f | declaredname }} = make({{$typ}}, {{ ((width $st $f)) }})
-				  for i := 0; i < {{ ((width $st $f)) }}; i++ {
-                {{else}}{{end}}
-
-				{{- if $f.Type | normal  }} x := {{ ((width $st $f)) }}; if n, err := w.Write(z.{{$f | name}}[:x]); err != nil || n != x  {return err}  {{else}} 
-				{{- if $f.Type | customslice   }} if err := z.{{$f | name}}.WriteBinary(w); err != nil { return err } {{else}}
-				{{- if $f.Type | binary  }} if err := binary.Write(w, {{$f | endian }}, z.{{$f | name}}); err != nil { return err } {{else}}
-				{{- if $f.Type | literal }} if n, err := w.Write([]byte({{$f | name}})); err != nil || n != len([]byte({{$f | name}})) {
-						if err != nil {
-							return return fmt.Errorf("z.%x: write %x instead", []byte({{$f | name}}, tmp)
-						}
+			{{- with $fn := $f | declaredname }}
+			{{ condopen $st $f }}
+			{
+			{{- if isconst $st $f }}
+				{{ constfield $st $f "Write" }}
+			{{- else if bitfield $st $f }}
+				{{ writebits $st $f }}
+			{{- else if ispad $st $f }}
+				{{ padfield $st $f "Write" }}
+			{{- else if union $st $f }}
+				if err := z.{{$fn}}.WriteBinary(w); err != nil {
+					return err
+				}
+			{{- else if rest $st $f }}
+				if _, err := w.Write(z.{{$fn}}); err != nil {
+					return err
+				}
+			{{- else if $f.Type | numslice }}
+				{{ numsfield $st $f "Write" }}
+			{{- else if $f.Type | customslice }}
+				x := {{ width $st $f }}
+				if len(z.{{$fn}}) < x {
+					return fmt.Errorf("{{$nm}}.{{$fn}}: have %d elements, want %d", len(z.{{$fn}}), x)
+				}
+				for i := 0; i < x; i++ {
+					{{- with nilcheck $st $f (printf "z.%s[i]" $fn) "Write" }}
+					{{.}}
+					{{- end }}
+					if err := z.{{$fn}}[i].WriteBinary(w); err != nil {
+						return err
+					}
+				}
+			{{- else if $f.Type | customarray }}
+				for i := range z.{{$fn}} {
+					{{- with nilcheck $st $f (printf "z.%s[i]" $fn) "Write" }}
+					{{.}}
+					{{- end }}
+					if err := z.{{$fn}}[i].WriteBinary(w); err != nil {
 						return err
-					}{{else}} 
-				{{ if $f.Type | wired }} if    err := z.{{$f | name}}.WriteBinary(w); err != nil { return err } ;
-				{{else}}{{ call bailout }}{{- end}}{{- end}}{{- end}}{{- end}}{{- end}}
-			{{- if $f.Type | unlooped }} } {{end}}{{end}}
-		{{- end}}
-				}{{end}}
+					}
+				}
+			{{- else if $f.Type | normal }}
+				x := {{ width $st $f }}
+				{{- if varwidth $st $f }}
+				if x < 0 {
+					return fmt.Errorf("{{$nm}}.{{$fn}}: negative width %d", x)
+				}
+				{{- end }}
+				if len(z.{{$fn}}) < x {
+					return fmt.Errorf("{{$nm}}.{{$fn}}: have %d bytes, want %d", len(z.{{$fn}}), x)
+				}
+				if _, err := w.Write(z.{{$fn}}[:x]); err != nil {
+					return err
+				}
+			{{- else if narrow $st $f }}
+				{{ narrowfield $st $f "Write" }}
+			{{- else if $f.Type | binary }}
+				if err := binary.Write(w, {{endian $st $f}}, z.{{$fn}}); err != nil {
+					return err
+				}
+			{{- else if $f.Type | wired }}
+				{{- with nilcheck $st $f (printf "z.%s" $fn) "Write" }}
+				{{.}}
+				{{- end }}
+				if err := z.{{$fn}}.WriteBinary(w); err != nil {
+					return err
+				}
+			{{- else }}{{ call bailout }}{{- end }}
+			}
+			{{- condclose $st $f }}
+			{{- end }}
+		{{- end }}
+		{{ frameend $st "Write" -}}
 		return nil
 	}
 {{end}}
 {{end}}
 `
-const ztWriteBinary = `
+const tMarshalBinary = `
+{{ with $nm := .Name | printf "%s" }}
+	// MarshalBinary implements encoding.BinaryMarshaler
+	func (z {{$nm}}) MarshalBinary() ([]byte, error) {
+		var buf bytes.Buffer
+		if err := z.WriteBinary(&buf); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+{{end}}
+`
+const tUnmarshalBinary = `
+{{ with $nm := .Name | printf "%s" }}
+	// UnmarshalBinary implements encoding.BinaryUnmarshaler. It
+	// returns an error if data is not consumed entirely.
+	func (z *{{$nm}}) UnmarshalBinary(data []byte) error {
+		r := bytes.NewReader(data)
+		if err := z.ReadBinary(r); err != nil {
+			return err
+		}
+		if n := r.Len(); n != 0 {
+			return fmt.Errorf("{{$nm}}: UnmarshalBinary: %d trailing bytes", n)
+		}
+		return nil
+	}
+{{end}}
+`
+const tBinarySize = `
 {{ with $st := . }}
 {{ with $nm := .Name | printf "%s" }}
-	func (z {{$nm}}) WriteBinary(w io.Writer) (err error) {
-		defer func() { recover() }()
-		{{ range $i, $f := $st | fields}}
-			{{with $nm  := $f | name}}{{with $len :=  ((width $st $f)) }}{{with $typ := $f | typeof }}
-			{{- if $f.Type | looped }} for i := 0; i < {{ ((width $st $f)) }}; i++ { {{ end }}
-				{
-				{{- if $f.Type | normal  }} x := {{ ((width $st $f)) }}; if n, err := w.Write(z.{{$f | name}}[:x]); err != nil || n != x  {return err}  {{else}} 
-				{{- if $f.Type | customslice   }} if err := z.{{$f | name}}.WriteBinary(w); err != nil { return err } {{else}}
-				{{- if $f.Type | binary  }} if err := binary.Write(w, {{$f | endian }}, z.{{$f | name}}); err != nil { return err } {{else}}
-				{{- if $f.Type | literal }} if n, err := w.Write([]byte({{$f | name}})); err != nil || n != len([]byte({{$f | name}})) {
-						if err != nil {
-							return return fmt.Errorf("z.%x: write %x instead", []byte({{$f | name}}, tmp)
-						}
-						return err
-					}{{else}} 
-				{{ if $f.Type | wired }} if    err := z.{{$f | name}}.WriteBinary(w); err != nil { return err } ;{{- end}}{{- end}}{{- end}}{{- end}}{{- end}}
-				}
-			{{ if $f.Type | unlooped }} }{{ end }}
-		{{end}}{{end}}{{end}}
+{{- $size := $st | staticsize }}
+{{- if ge $size 0 }}
+	// {{$nm}}Size is the binary width of {{$nm}}
+	const {{$nm}}Size = {{$size}}
+
+	// BinarySize returns the length of z's binary encoding
+	func (z {{$nm}}) BinarySize() int {
+		return {{$nm}}Size
+	}
+{{- else }}
+	// BinarySize returns the length of z's binary encoding
+	func (z {{$nm}}) BinarySize() (n int) {
+		{{- if haslengths $st }}
+		// Size the counts as WriteBinary writes them; z is a copy.
+		z.SetLengths()
+		{{- end }}
+		{{- range $i, $f := $st | fields }}
+		{{ sizeof $st $f }}
+		{{- end }}
+		return n
+	}
+{{- end }}
+{{end}}
 {{end}}
+`
+const tSetLengths = `
+{{ with $st := . }}
+{{- if haslengths $st }}
+{{ with $nm := .Name | printf "%s" }}
+	// SetLengths sets the count fields of z from the lengths of the
+	// slices they count. WriteBinary calls it before writing.
+	func (z *{{$nm}}) SetLengths() error {
+		{{ setlengths $st -}}
 		return nil
 	}
 {{end}}
+{{- end }}
+{{end}}
+`
+const tDecodeFrom = `
+{{ with $st := . }}
+{{ with $nm := .Name | printf "%s" }}
+	// DecodeFrom decodes z from b and returns the number of bytes read.
+	// Slices in z are resliced when their capacity allows it.
+	func (z *{{$nm}}) DecodeFrom(b []byte) (n int, err error) {
+		var field string
+		var off int
+		defer func() {
+			if err != nil {
+				err = wire.Annotate(err, "{{$nm}}", field, int64(off))
+			}
+		}()
+		{{- range $i, $f := $st | fields}}
+			{{- with $fn := $f | declaredname }}
+			field, off = "{{ fieldname $st $f }}", n
+			{{ condopen $st $f }}
+			{
+			{{- if isconst $st $f }}
+				{{ constfield $st $f "Decode" }}
+			{{- else if bitfield $st $f }}
+				{{ decodebits $st $f }}
+			{{- else if ispad $st $f }}
+				{{ padfield $st $f "Decode" }}
+			{{- else if union $st $f }}
+				{{ decodeunion $st $f }}
+			{{- else if rest $st $f }}
+				{{- with maxlen $st $f }}
+				if len(b)-n > {{.}} {
+					return n, &wire.LengthError{Len: len(b) - n, Max: {{.}}}
+				}
+				{{- end }}
+				z.{{$fn}} = append(z.{{$fn}}[:0], b[n:]...)
+				n = len(b)
+			{{- else if $f.Type | numslice }}
+				{{ numsfield $st $f "Decode" }}
+			{{- else if $f.Type | customslice }}
+				x := {{ width $st $f }}
+				{{- if varwidth $st $f }}
+				if x < 0 {
+					return n, fmt.Errorf("negative width %d", x)
+				}
+				{{- end }}
+				{{- with maxlen $st $f }}
+				if x > {{.}} {
+					return n, &wire.LengthError{Len: x, Max: {{.}}}
+				}
+				{{- end }}
+				z.{{$fn}} = z.{{$fn}}[:0]
+				for i := 0; i < x; i++ {
+					off = n
+					if i < cap(z.{{$fn}}) {
+						z.{{$fn}} = z.{{$fn}}[:i+1]
+					} else {
+						var v {{$f.Type | elem}}
+						z.{{$fn}} = append(z.{{$fn}}, v)
+					}
+					{{- with nilcheck $st $f (printf "z.%s[i]" $fn) "Decode" }}
+					{{.}}
+					{{- end }}
+					m, err := z.{{$fn}}[i].DecodeFrom(b[n:])
+					n += m
+					if err != nil {
+						return n, err
+					}
+				}
+			{{- else if $f.Type | customarray }}
+				for i := range z.{{$fn}} {
+					off = n
+					{{- with nilcheck $st $f (printf "z.%s[i]" $fn) "Decode" }}
+					{{.}}
+					{{- end }}
+					m, err := z.{{$fn}}[i].DecodeFrom(b[n:])
+					n += m
+					if err != nil {
+						return n, err
+					}
+				}
+			{{- else if $f.Type | normal }}
+				x := {{ width $st $f }}
+				{{- if varwidth $st $f }}
+				if x < 0 {
+					return n, fmt.Errorf("negative width %d", x)
+				}
+				{{- end }}
+				{{- with maxlen $st $f }}
+				if x > {{.}} {
+					return n, &wire.LengthError{Len: x, Max: {{.}}}
+				}
+				{{- end }}
+				if len(b)-n < x {
+					return n, ioErr("read", len(b)-n, x)
+				}
+				z.{{$fn}} = append(z.{{$fn}}[:0], b[n:n+x]...)
+				n += x
+			{{- else if narrow $st $f }}
+				{{ narrowfield $st $f "Decode" }}
+			{{- else if $f.Type | binary }}
+				if len(b)-n < {{ numsize $f }} {
+					return n, ioErr("read", len(b)-n, {{ numsize $f }})
+				}
+				{{ decodenum $st $f }}
+				n += {{ numsize $f }}
+			{{- else if $f.Type | wired }}
+				{{- with nilcheck $st $f (printf "z.%s" $fn) "Decode" }}
+				{{.}}
+				{{- end }}
+				m, err := z.{{$fn}}.DecodeFrom(b[n:])
+				n += m
+				if err != nil {
+					return n, err
+				}
+			{{- else }}{{ call bailout }}{{- end }}
+			}
+			{{- condclose $st $f }}
+			{{ framefield $st $f "Decode" -}}
+			{{- end }}
+		{{- end }}
+		{{ frameend $st "Decode" -}}
+		return n, nil
+	}
+{{end}}
+{{end}}
+`
+const tAppendBinary = `
+{{ with $st := . }}
+{{ with $nm := .Name | printf "%s" }}
+	// AppendBinary appends the binary encoding of z to b.
+	func (z {{$nm}}) AppendBinary(b []byte) (_ []byte, err error) {
+		{{- if haslengths $st }}
+		if err := z.SetLengths(); err != nil {
+			return b, err
+		}
+		{{- end }}
+		{{ settags $st "b, " }}
+		{{ framestart $st "Append" -}}
+		{{ alignstart $st "Append" -}}
+		{{- range $i, $f := $st | fields}}
+			{{- with $fn := $f | declaredname }}
+			{{ condopen $st $f }}
+			{
+			{{- if isconst $st $f }}
+				{{ constfield $st $f "Append" }}
+			{{- else if bitfield $st $f }}
+				{{ appendbits $st $f }}
+			{{- else if ispad $st $f }}
+				{{ padfield $st $f "Append" }}
+			{{- else if union $st $f }}
+				if b, err = z.{{$fn}}.AppendBinary(b); err != nil {
+					return b, err
+				}
+			{{- else if rest $st $f }}
+				b = append(b, z.{{$fn}}...)
+			{{- else if $f.Type | numslice }}
+				{{ numsfield $st $f "Append" }}
+			{{- else if $f.Type | customslice }}
+				x := {{ width $st $f }}
+				if len(z.{{$fn}}) < x {
+					return b, fmt.Errorf("{{$nm}}.{{$fn}}: have %d elements, want %d", len(z.{{$fn}}), x)
+				}
+				for i := 0; i < x; i++ {
+					{{- with nilcheck $st $f (printf "z.%s[i]" $fn) "Append" }}
+					{{.}}
+					{{- end }}
+					if b, err = z.{{$fn}}[i].AppendBinary(b); err != nil {
+						return b, err
+					}
+				}
+			{{- else if $f.Type | customarray }}
+				for i := range z.{{$fn}} {
+					{{- with nilcheck $st $f (printf "z.%s[i]" $fn) "Append" }}
+					{{.}}
+					{{- end }}
+					if b, err = z.{{$fn}}[i].AppendBinary(b); err != nil {
+						return b, err
+					}
+				}
+			{{- else if $f.Type | normal }}
+				x := {{ width $st $f }}
+				{{- if varwidth $st $f }}
+				if x < 0 {
+					return b, fmt.Errorf("{{$nm}}.{{$fn}}: negative width %d", x)
+				}
+				{{- end }}
+				if len(z.{{$fn}}) < x {
+					return b, fmt.Errorf("{{$nm}}.{{$fn}}: have %d bytes, want %d", len(z.{{$fn}}), x)
+				}
+				b = append(b, z.{{$fn}}[:x]...)
+			{{- else if narrow $st $f }}
+				{{ narrowfield $st $f "Append" }}
+			{{- else if $f.Type | binary }}
+				{{ appendnum $st $f }}
+			{{- else if $f.Type | wired }}
+				{{- with nilcheck $st $f (printf "z.%s" $fn) "Append" }}
+				{{.}}
+				{{- end }}
+				if b, err = z.{{$fn}}.AppendBinary(b); err != nil {
+					return b, err
+				}
+			{{- else }}{{ call bailout }}{{- end }}
+			}
+			{{- condclose $st $f }}
+			{{- end }}
+		{{- end }}
+		{{ frameend $st "Append" -}}
+		return b, nil
+	}
+{{end}}
 {{end}}
 `
@@ -0,0 +1,171 @@
+package wire9
+
+import (
+	"fmt"
+	"go/ast"
+	goparser "go/parser"
+	"go/token"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// stdImports maps the package names used by generated code to their
+// import paths. The packages of types named in wire definitions are
+// taken from the imports in the source instead; see Imports.
+var stdImports = map[string]string{
+	"binary": "encoding/binary",
+	"bytes":  "bytes",
+	"errors": "errors",
+	"fmt":    "fmt",
+	"io":     "io",
+	"math":   "math",
+	"varint": "github.com/as/wire9/varint",
+	"wire":   "github.com/as/wire9/wire",
+}
+
+// PackageName returns the name of the package the files belong to. Files
+// in an external test package are ignored. The default is main.
+func PackageName(files []*ast.File) string {
+	for _, f := range files {
+		if f.Name != nil && !strings.HasSuffix(f.Name.Name, "_test") {
+			return f.Name.Name
+		}
+	}
+	return "main"
+}
+
+// Imports returns the import specs needed by the generated source body. A
+// package is imported if body selects from it. Standard library packages
+// come first, separated from the others by an empty spec.
+//
+// Refs holds the qualified types the wire definitions name, as returned
+// by TypeRefs. Their packages are taken from the imports of files, such
+// as the image in p[,image.Point]. Other selectors are the generator's
+// own and use stdImports. A package name used both ways for different
+// paths is an error.
+func Imports(body []byte, files []*ast.File, refs map[string]bool) ([]string, error) {
+	user := make(map[string]string)
+	for _, f := range files {
+		for _, spec := range f.Imports {
+			p, err := strconv.Unquote(spec.Path.Value)
+			if err != nil {
+				return nil, err
+			}
+			name := path.Base(p)
+			if spec.Name != nil {
+				name = spec.Name.Name
+			}
+			if name != "_" && name != "." {
+				user[name] = p
+			}
+		}
+	}
+
+	src := append([]byte("package x\n"), body...)
+	f, err := goparser.ParseFile(token.NewFileSet(), "", src, 0)
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]string)
+	var conflict error
+	ast.Inspect(f, func(n ast.Node) bool {
+		sel, ok := n.(*ast.SelectorExpr)
+		if !ok || conflict != nil {
+			return conflict == nil
+		}
+		// An unresolved identifier is not declared in the
+		// generated code, so it must be a package name.
+		x, ok := sel.X.(*ast.Ident)
+		if !ok || x.Obj != nil {
+			return true
+		}
+		p, ok := stdImports[x.Name]
+		if up, uok := user[x.Name]; uok && refs[x.Name+"."+sel.Sel.Name] {
+			p, ok = up, true
+		}
+		if !ok {
+			return true
+		}
+		if have, dup := known[x.Name]; dup && have != p {
+			conflict = fmt.Errorf("package name %s refers to both %q and %q; import one of them under another name", x.Name, have, p)
+			return false
+		}
+		known[x.Name] = p
+		return true
+	})
+	if conflict != nil {
+		return nil, conflict
+	}
+
+	names := make([]string, 0, len(known))
+	for name := range known {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		pi, pj := known[names[i]], known[names[j]]
+		if std(pi) != std(pj) {
+			return std(pi)
+		}
+		return pi < pj
+	})
+
+	specs := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		p := known[name]
+		if i > 0 && std(known[names[i-1]]) && !std(p) {
+			specs = append(specs, "")
+		}
+		spec := strconv.Quote(p)
+		if path.Base(p) != name {
+			spec = fmt.Sprintf("%s %q", name, p)
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// std returns true if the import path p is in the standard library
+func std(p string) bool {
+	return !strings.Contains(strings.SplitN(p, "/", 2)[0], ".")
+}
+
+// TypeRefs returns the qualified type names, as in image.Point, that the
+// wire definitions of src name in their fields and union cases. The
+// types of varint keywords are the generator's own and are left out.
+func (src *Source) TypeRefs() map[string]bool {
+	refs := make(map[string]bool)
+	add := func(x ast.Expr) {
+		if x == nil {
+			return
+		}
+		for _, t := range varintTypes {
+			if x == t {
+				return
+			}
+		}
+		ast.Inspect(x, func(n ast.Node) bool {
+			if sel, ok := n.(*ast.SelectorExpr); ok {
+				if id, ok := sel.X.(*ast.Ident); ok {
+					refs[id.Name+"."+sel.Sel.Name] = true
+				}
+			}
+			return true
+		})
+	}
+	for _, file := range src.Files {
+		for _, ts := range file.Structs {
+			for _, f := range ts.Type.(*ast.StructType).Fields.List {
+				add(f.Type)
+				if info := TInfo.Get(ts, f); info != nil && info.Union != nil {
+					for _, c := range info.Union.Cases {
+						add(c.Type)
+					}
+					add(info.Union.Default)
+				}
+			}
+		}
+	}
+	return refs
+}
@@ -0,0 +1,122 @@
+package wire9
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"math"
+)
+
+// counted associates a count field with the slices whose number of
+// elements it holds, as n and data in n[4] data[n].
+type counted struct {
+	count  *ast.Field
+	slices []*ast.Field
+}
+
+// countedSlices returns the count fields of ts in order of appearance.
+// Frame sizes are not counts; they are set by the frame.
+func countedSlices(ts *ast.TypeSpec) (cs []counted) {
+	index := make(map[string]int)
+	for _, f := range ts.Type.(*ast.StructType).Fields.List {
+		info := TInfo.Get(ts, f)
+		if info == nil || info.Rest || info.Union != nil || info.Pad || !Slice(f.Type) {
+			continue
+		}
+		id, ok := info.Width.(*ast.Ident)
+		if !ok {
+			continue
+		}
+		count := fieldNamed(ts, id.Name)
+		if count == nil || !countable(ts, count) {
+			continue
+		}
+		i, ok := index[id.Name]
+		if !ok {
+			i = len(cs)
+			index[id.Name] = i
+			cs = append(cs, counted{count: count})
+		}
+		cs[i].slices = append(cs[i].slices, f)
+	}
+	return cs
+}
+
+// countable returns true if f is an integer or varint field that is
+// not a frame size
+func countable(ts *ast.TypeSpec, f *ast.Field) bool {
+	info := TInfo.Get(ts, f)
+	if info == nil || info.Frame {
+		return false
+	}
+	_, ok := intTypes[TypeString(f.Type)]
+	return ok || Varint(f.Type)
+}
+
+// maxCount returns the largest value the integer field f holds. The
+// second value is false if f holds any length.
+func maxCount(ts *ast.TypeSpec, f *ast.Field) (uint64, bool) {
+	var max uint64
+	if Varint(f.Type) {
+		max = varintMax[TypeString(f.Type)]
+	} else if info := TInfo.Get(ts, f); info != nil && info.Bits != 0 {
+		max = uint64(1)<<uint(info.Bits) - 1
+	} else {
+		n, _ := NumSize(f)
+		if w, ok := narrowWidth(ts, f); ok {
+			n = w
+		}
+		max = uint64(1)<<uint(8*n) - 1
+		if intTypes[TypeString(f.Type)] {
+			max >>= 1
+		}
+	}
+	return max, max < math.MaxInt64
+}
+
+// HasLengths returns true if ts has count fields maintained by SetLengths
+func HasLengths(ts *ast.TypeSpec) bool {
+	return len(countedSlices(ts)) > 0
+}
+
+// SetLengths returns statements setting every count field of ts from
+// the length of the slices it counts. The slices counted by the same
+// field must have the same length, and none if the count is absent.
+func SetLengths(ts *ast.TypeSpec) string {
+	b := new(bytes.Buffer)
+	for _, c := range countedSlices(ts) {
+		count := c.count.Names[0].Name
+		set := func(x string) {
+			if info := TInfo.Get(ts, c.count); info.Cond != nil {
+				fmt.Fprintf(b, "if !(%s) {\nif %s != 0 {\n", Cond(ts, info.Cond), x)
+				fmt.Fprintf(b, "return fmt.Errorf(\"%s.%s: count is absent but its slices hold %%d elements\", %s)\n}\n} else {\n", ts.Name.Name, count, x)
+				defer fmt.Fprintf(b, "}\n")
+			}
+			if max, ok := maxCount(ts, c.count); ok {
+				fmt.Fprintf(b, "if uint64(%s) > %#x {\n", x, max)
+				fmt.Fprintf(b, "return fmt.Errorf(\"%s.%s: length %%d is too large\", %s)\n}\n", ts.Name.Name, count, x)
+			}
+			fmt.Fprintf(b, "z.%s = %s(%s)\n", count, TypeString(c.count.Type), x)
+		}
+		if len(c.slices) == 1 && CondOpen(ts, c.slices[0]) == "" {
+			set(fmt.Sprintf("len(z.%s)", c.slices[0].Names[0].Name))
+			continue
+		}
+		// Several slices, or ones that may be absent: the first one
+		// present sets the length the others must have.
+		fmt.Fprintf(b, "{\nx := -1\n")
+		for _, f := range c.slices {
+			fn := f.Names[0].Name
+			fmt.Fprintf(b, "%s\n", CondOpen(ts, f))
+			fmt.Fprintf(b, "if x >= 0 && len(z.%s) != x {\n", fn)
+			fmt.Fprintf(b, "return fmt.Errorf(\"%s.%s: length %%d differs from %%d of the other slices counted by %s\", len(z.%s), x)\n}\n",
+				ts.Name.Name, fn, count, fn)
+			fmt.Fprintf(b, "x = len(z.%s)\n", fn)
+			fmt.Fprintf(b, "%s\n", CondClose(ts, f))
+		}
+		fmt.Fprintf(b, "if x >= 0 {\n")
+		set("x")
+		fmt.Fprintf(b, "}\n}\n")
+	}
+	return b.String()
+}
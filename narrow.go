@@ -0,0 +1,93 @@
+package wire9
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"go/ast"
+)
+
+// narrowWidth returns the width in bytes of the integer field f if it
+// is narrower than its type, as in len[3] or off[3,int32]. Signed
+// integers are sign extended on read.
+func narrowWidth(ts *ast.TypeSpec, f *ast.Field) (int, bool) {
+	info := TInfo.Get(ts, f)
+	if info == nil || info.Bits != 0 || info.Const != nil {
+		return 0, false
+	}
+	if _, ok := intTypes[TypeString(f.Type)]; !ok {
+		return 0, false
+	}
+	w, ok := ConstWidth(info.Width)
+	size, _ := NumSize(f)
+	return w, ok && w > 0 && w < size
+}
+
+// Narrow returns true if f is an integer field narrower than its type
+func Narrow(ts *ast.TypeSpec, f *ast.Field) bool {
+	_, ok := narrowWidth(ts, f)
+	return ok
+}
+
+// NarrowField returns a block reading, writing, decoding or appending
+// the narrow integer f, as selected by method. On Write and Append, the
+// block fails if the value does not fit.
+func NarrowField(ts *ast.TypeSpec, f *ast.Field, method string) (string, error) {
+	n, ok := narrowWidth(ts, f)
+	if !ok {
+		return "", fmt.Errorf("%s.%s: not a narrow integer", ts.Name.Name, f.Names[0].Name)
+	}
+	typ, fn := TypeString(f.Type), f.Names[0].Name
+	be := TInfo.Get(ts, f).Endian == binary.BigEndian
+	shift := 64 - 8*n
+	b := new(bytes.Buffer)
+	switch method {
+	case "Read", "Decode":
+		if method == "Read" {
+			fmt.Fprintf(b, "var buf [%d]byte\n", n)
+			fmt.Fprintf(b, "if m, err := io.ReadFull(r, buf[:]); err == io.ErrUnexpectedEOF || err == io.EOF && rc.N != 0 {\n")
+			fmt.Fprintf(b, "return ioErr(\"read\", m, %d)\n} else if err != nil {\nreturn err\n}\n", n)
+		} else {
+			fmt.Fprintf(b, "if len(b)-n < %d {\nreturn n, ioErr(\"read\", len(b)-n, %d)\n}\n", n, n)
+			fmt.Fprintf(b, "buf := b[n : n+%d]\n", n)
+		}
+		fmt.Fprintf(b, "var v uint64\n")
+		if be {
+			fmt.Fprintf(b, "for _, c := range buf {\nv = v<<8 | uint64(c)\n}\n")
+		} else {
+			fmt.Fprintf(b, "for i := len(buf) - 1; i >= 0; i-- {\nv = v<<8 | uint64(buf[i])\n}\n")
+		}
+		if intTypes[typ] {
+			fmt.Fprintf(b, "z.%s = %s(int64(v<<%d) >> %d)\n", fn, typ, shift, shift)
+		} else {
+			fmt.Fprintf(b, "z.%s = %s(v)\n", fn, typ)
+		}
+		if method == "Decode" {
+			fmt.Fprintf(b, "n += %d\n", n)
+		}
+	case "Write", "Append":
+		ret := ""
+		if method == "Append" {
+			ret = "b, "
+		}
+		fmt.Fprintf(b, "v := uint64(z.%s)\n", fn)
+		if intTypes[typ] {
+			fmt.Fprintf(b, "if int64(v<<%d)>>%d != int64(z.%s) {\n", shift, shift, fn)
+		} else {
+			fmt.Fprintf(b, "if v>>%d != 0 {\n", 8*n)
+		}
+		fmt.Fprintf(b, "return %sfmt.Errorf(\"%s.%s: %%d does not fit in %d bytes\", z.%s)\n}\n", ret, ts.Name.Name, fn, n, fn)
+		fmt.Fprintf(b, "var buf [%d]byte\n", n)
+		if be {
+			fmt.Fprintf(b, "for i := range buf {\nbuf[i] = byte(v >> (8 * (%d - i)))\n}\n", n-1)
+		} else {
+			fmt.Fprintf(b, "for i := range buf {\nbuf[i] = byte(v >> (8 * i))\n}\n")
+		}
+		if method == "Write" {
+			fmt.Fprintf(b, "if _, err := w.Write(buf[:]); err != nil {\nreturn err\n}\n")
+		} else {
+			fmt.Fprintf(b, "b = append(b, buf[:]...)\n")
+		}
+	}
+	return b.String(), nil
+}
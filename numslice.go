@@ -0,0 +1,131 @@
+package wire9
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+)
+
+// NumSliceField returns a block reading, writing, decoding or appending
+// the numeric slice or array f, as selected by method. The elements are
+// encoded in one buffer rather than one call per element.
+func NumSliceField(ts *ast.TypeSpec, f *ast.Field, method string) (string, error) {
+	w, err := WidthOf(ts, f)
+	if err != nil {
+		return "", err
+	}
+	elt := TypeString(f.Type.(*ast.ArrayType).Elt)
+	c := numCodecs[elt]
+	if Array(f.Type) {
+		return numArrayField(ts, f, method, elt, c)
+	}
+	typ, fn, order := TypeString(f.Type), f.Names[0].Name, Endian(ts, f)
+	at := func(base string) string { return elemAt(base, c.size) }
+	ret := map[string]string{"Decode": "n, ", "Append": "b, "}[method]
+	name := ""
+	if method == "Write" || method == "Append" {
+		name = ts.Name.Name + "." + fn + ": "
+	}
+
+	b := new(bytes.Buffer)
+	fmt.Fprintf(b, "x := %s\n", w)
+	if VarWidth(ts, f) {
+		fmt.Fprintf(b, "if x < 0 {\nreturn %sfmt.Errorf(\"%snegative width %%d\", x)\n}\n", ret, name)
+	}
+	switch method {
+	case "Read", "Decode":
+		max := fmt.Sprintf("%d", MaxLen(ts, f))
+		if max == "0" && method == "Read" && VarWidth(ts, f) && c.size != 1 {
+			max = fmt.Sprintf("math.MaxInt / %d", c.size)
+		}
+		if max != "0" {
+			fmt.Fprintf(b, "if x > %s {\nreturn %s&wire.LengthError{Len: x, Max: %s}\n}\n", max, ret, max)
+		}
+		if method == "Read" {
+			fmt.Fprintf(b, "b, err := wire.ReadN(r, x*%d)\n", c.size)
+			fmt.Fprintf(b, "if err == io.ErrUnexpectedEOF || err == io.EOF && rc.N != 0 {\n")
+			fmt.Fprintf(b, "return ioErr(\"read\", len(b), x*%d)\n} else if err != nil {\nreturn err\n}\n", c.size)
+			fmt.Fprintf(b, "z.%s = make(%s, x)\n", fn, typ)
+			fmt.Fprintf(b, "for i := range z.%s {\nz.%s[i] = %s\n}\n", fn, fn, expand(c.get, order, at(""), "", elt))
+		} else {
+			fmt.Fprintf(b, "if (len(b)-n)/%d < x {\nreturn n, ioErr(\"read\", len(b)-n, x*%d)\n}\n", c.size, c.size)
+			fmt.Fprintf(b, "if cap(z.%s) < x {\nz.%s = make(%s, x)\n}\n", fn, fn, typ)
+			fmt.Fprintf(b, "z.%s = z.%s[:x]\n", fn, fn)
+			fmt.Fprintf(b, "for i := range z.%s {\nz.%s[i] = %s\n}\n", fn, fn, expand(c.get, order, at("n"), "", elt))
+			fmt.Fprintf(b, "n += x * %d\n", c.size)
+		}
+	case "Write", "Append":
+		fmt.Fprintf(b, "if len(z.%s) < x {\n", fn)
+		fmt.Fprintf(b, "return %sfmt.Errorf(\"%shave %%d elements, want %%d\", len(z.%s), x)\n}\n", ret, name, fn)
+		if method == "Write" {
+			fmt.Fprintf(b, "b := make([]byte, 0, x*%d)\n", c.size)
+		}
+		fmt.Fprintf(b, "for _, v := range z.%s[:x] {\n%s\n}\n", fn, expand(c.put, order, "", "v", elt))
+		if method == "Write" {
+			fmt.Fprintf(b, "if _, err := w.Write(b); err != nil {\nreturn err\n}\n")
+		}
+	}
+	return b.String(), nil
+}
+
+// elemAt returns the offset of the i'th element of the given size
+// from base
+func elemAt(base string, size int) string {
+	i := "i"
+	if size != 1 {
+		i = fmt.Sprintf("%d*i", size)
+	}
+	if base != "" {
+		i = base + "+" + i
+	}
+	return i
+}
+
+// numArrayField is NumSliceField for arrays. Arrays have a constant
+// length, so they are read in place and need no length checks. Byte
+// arrays are copied directly.
+func numArrayField(ts *ast.TypeSpec, f *ast.Field, method, elt string, c numCodec) (string, error) {
+	fn, order := f.Names[0].Name, Endian(ts, f)
+	n, _ := ConstWidth(TInfo.Get(ts, f).Width)
+	size := n * c.size
+	at := func(base string) string { return elemAt(base, c.size) }
+	raw := elt == "byte" || elt == "uint8"
+
+	b := new(bytes.Buffer)
+	switch method {
+	case "Read":
+		buf := "z." + fn
+		if !raw {
+			buf = "b"
+			fmt.Fprintf(b, "var b [%d]byte\n", size)
+		}
+		fmt.Fprintf(b, "if m, err := io.ReadFull(r, %s[:]); err == io.ErrUnexpectedEOF || err == io.EOF && rc.N != 0 {\n", buf)
+		fmt.Fprintf(b, "return ioErr(\"read\", m, %d)\n} else if err != nil {\nreturn err\n}\n", size)
+		if !raw {
+			fmt.Fprintf(b, "for i := range z.%s {\nz.%s[i] = %s\n}\n", fn, fn, expand(c.get, order, at(""), "", elt))
+		}
+	case "Decode":
+		fmt.Fprintf(b, "if len(b)-n < %d {\nreturn n, ioErr(\"read\", len(b)-n, %d)\n}\n", size, size)
+		if raw {
+			fmt.Fprintf(b, "copy(z.%s[:], b[n:])\n", fn)
+		} else {
+			fmt.Fprintf(b, "for i := range z.%s {\nz.%s[i] = %s\n}\n", fn, fn, expand(c.get, order, at("n"), "", elt))
+		}
+		fmt.Fprintf(b, "n += %d\n", size)
+	case "Write":
+		if raw {
+			fmt.Fprintf(b, "if _, err := w.Write(z.%s[:]); err != nil {\nreturn err\n}\n", fn)
+			break
+		}
+		fmt.Fprintf(b, "b := make([]byte, 0, %d)\n", size)
+		fmt.Fprintf(b, "for _, v := range z.%s {\n%s\n}\n", fn, expand(c.put, order, "", "v", elt))
+		fmt.Fprintf(b, "if _, err := w.Write(b); err != nil {\nreturn err\n}\n")
+	case "Append":
+		if raw {
+			fmt.Fprintf(b, "b = append(b, z.%s[:]...)\n", fn)
+			break
+		}
+		fmt.Fprintf(b, "for _, v := range z.%s {\n%s\n}\n", fn, expand(c.put, order, "", "v", elt))
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,99 @@
+package wire9
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+)
+
+// IsPad returns true if f is padding, as in _[3] or align(4). Padding
+// has no struct field; it is skipped on read and zero-filled on write.
+func IsPad(ts *ast.TypeSpec, f *ast.Field) bool {
+	info := TInfo.Get(ts, f)
+	return info != nil && info.Pad
+}
+
+// FieldName returns the name of f used in errors. Padding is named as
+// declared, _ or align(n), rather than by the name the parser gave it.
+func FieldName(ts *ast.TypeSpec, f *ast.Field) string {
+	if info := TInfo.Get(ts, f); info != nil && info.Pad {
+		if info.Align != 0 {
+			return fmt.Sprintf("align(%d)", info.Align)
+		}
+		return "_"
+	}
+	return f.Names[0].Name
+}
+
+// HasAlign returns true if ts has an align field
+func HasAlign(ts *ast.TypeSpec) bool {
+	for _, f := range ts.Type.(*ast.StructType).Fields.List {
+		if info := TInfo.Get(ts, f); info != nil && info.Align != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// AlignStart returns statements recording the start of the message for
+// the align fields of ts. Method is one of Write or Append; ReadBinary
+// and DecodeFrom already track their offset.
+func AlignStart(ts *ast.TypeSpec, method string) string {
+	if !HasAlign(ts) {
+		return ""
+	}
+	switch method {
+	case "Write":
+		return "wc := &wire.WriteCounter{W: w}\nw = wc\n"
+	case "Append":
+		return "base := len(b)\n"
+	}
+	return ""
+}
+
+// alignPad returns the number of bytes padding size to a multiple of n
+func alignPad(size, n int) int {
+	return (n - size%n) % n
+}
+
+// PadField returns a block skipping, writing, decoding or appending the
+// padding f, as selected by method. The width of an align field depends
+// on the offset of the field from the start of the message.
+func PadField(ts *ast.TypeSpec, f *ast.Field, method string) (string, error) {
+	info := TInfo.Get(ts, f)
+	b := new(bytes.Buffer)
+	if a := info.Align; a != 0 {
+		off := map[string]string{
+			"Read":   "int(rc.N)",
+			"Write":  "int(wc.N)",
+			"Decode": "n",
+			"Append": "len(b) - base",
+		}[method]
+		fmt.Fprintf(b, "x := (%d - (%s)%%%d) %% %d\n", a, off, a, a)
+	} else {
+		w, err := WidthOf(ts, f)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(b, "x := %s\n", w)
+		if VarWidth(ts, f) {
+			ret, name := map[string]string{"Decode": "n, ", "Append": "b, "}[method], ""
+			if method == "Write" || method == "Append" {
+				name = ts.Name.Name + "." + FieldName(ts, f) + ": "
+			}
+			fmt.Fprintf(b, "if x < 0 {\nreturn %sfmt.Errorf(\"%snegative width %%d\", x)\n}\n", ret, name)
+		}
+	}
+	switch method {
+	case "Read":
+		fmt.Fprintf(b, "if m, err := io.CopyN(io.Discard, r, int64(x)); err == io.EOF && rc.N != 0 {\n")
+		fmt.Fprintf(b, "return ioErr(\"read\", int(m), x)\n} else if err != nil {\nreturn err\n}\n")
+	case "Write":
+		fmt.Fprintf(b, "if _, err := w.Write(make([]byte, x)); err != nil {\nreturn err\n}\n")
+	case "Decode":
+		fmt.Fprintf(b, "if len(b)-n < x {\nreturn n, ioErr(\"read\", len(b)-n, x)\n}\nn += x\n")
+	case "Append":
+		fmt.Fprintf(b, "b = append(b, make([]byte, x)...)\n")
+	}
+	return b.String(), nil
+}
@@ -6,6 +6,7 @@ import (
 	"go/ast"
 	"go/scanner"
 	"go/token"
+	"strconv"
 	"strings"
 )
 
@@ -52,6 +53,15 @@ type parser struct {
 
 	DupMap    DupMap
 	intDupMap DupMap
+
+	// Default byte order for fields without an endian option
+	endian binary.ByteOrder
+
+	// Packing order of bit fields
+	bitorder BitOrder
+
+	// Number of padding fields, which are named _1, _2 and so on
+	pads int
 }
 
 // NewParser returns an initialized parser.
@@ -83,21 +93,62 @@ func (p *parser) parseDefinition() *ast.TypeSpec {
 		p.error(p.pos, "struct missing name")
 		return nil
 	}
+	if p.tok == token.LSS {
+		p.parseDefOptions()
+	}
 	S := &ast.TypeSpec{
 		Name: name,
 		Type: &ast.StructType{Fields: &ast.FieldList{List: make([]*ast.Field, 0)}},
 	}
 	fp := S.Type.(*ast.StructType).Fields
+	var infos []*Info
+	consts := make(map[string]bool)
 	for p.tok != token.SEMICOLON && p.tok != token.EOF {
 		f, i := p.parseWireField()
+		if i != nil {
+			p.checkConstRefs(consts, i.Width, "width")
+			p.checkConstRefs(consts, i.Cond, "condition")
+			if i.Union != nil {
+				p.checkConstRefs(consts, i.Union.Tag, "switch tag")
+			}
+			if i.Const != nil {
+				consts[f.Names[0].Name] = true
+			}
+		}
 
+		if i != nil && i.Union != nil {
+			p.checkRefs(fp.List, i.Union.Tag, "switch tag")
+			f.Type = &ast.Ident{Name: UnionName(name.Name, f.Names[0].Name)}
+		}
+		if i != nil && i.Cond != nil {
+			p.checkRefs(fp.List, i.Cond, "condition")
+		}
 		fp.List = append(fp.List, f)
+		infos = append(infos, i)
 		TInfo.Add(S, f, i)
 	}
 	if fp.List == nil {
 		p.error(p.pos, "empty field list")
 		return nil
 	}
+	frames := 0
+	for i, info := range infos {
+		if info != nil && info.Rest && i != len(infos)-1 {
+			p.error(p.pos, fmt.Sprintf("field %s: rest of input must be the last field", fp.List[i].Names[0].Name))
+			return nil
+		}
+		if info != nil && info.Frame {
+			frames++
+		}
+	}
+	if frames > 1 {
+		p.error(p.pos, "multiple frame sizes")
+		return nil
+	}
+	if err := layoutBits(fp.List, infos, p.bitorder); err != nil {
+		p.error(p.pos, err.Error())
+		return nil
+	}
 	p.expectSemi()
 
 	return S
@@ -108,28 +159,139 @@ func (p *parser) parseWireField() (*ast.Field, *Info) {
 		defer un(trace(p, "WireField"))
 	}
 	name := p.parseIdent()
+	if name.Name == "align" && p.tok == token.LPAREN {
+		return p.parseAlignField()
+	}
+	pad, label := name.Name == "_", name.Name
+	if pad {
+		// Padding has no struct field, but needs a unique name;
+		// errors use the declared one, label
+		p.pads++
+		name.Name = fmt.Sprintf("_%d", p.pads)
+	}
 	p.expect(token.LBRACK)
 	p.exprLev++
 	defer func() { p.exprLev-- }()
 
 	var flag WidthFlag
-	width := p.parseWireWidth()
+	if p.tok == token.MUL {
+		if pad {
+			p.error(p.pos, "padding cant hold the rest of the input")
+			return nil, nil
+		}
+		return p.parseRestField(name)
+	}
+	width, isbits := p.parseWireWidth()
+	if id, ok := width.(*ast.Ident); ok && !isbits && varintTypes[id.Name] != nil {
+		if pad {
+			p.error(p.pos, "padding cant be a varint")
+			return nil, nil
+		}
+		return p.parseVarintField(name, id)
+	}
 	if width != nil {
 		switch width.(type) {
 		case *ast.BasicLit:
 			flag |= WidthLit
-		case *ast.Ident, *ast.BinaryExpr:
+		case *ast.Ident, *ast.BinaryExpr, *ast.ParenExpr, *ast.UnaryExpr:
 			flag |= WidthVar
 		default:
 			panic("parseWireField " + fmt.Sprintf("%T", width))
 		}
 	}
+	bits := 0
+	if isbits {
+		n, ok := ConstWidth(width)
+		if !ok || n < 1 || n > maxBits {
+			p.error(width.Pos(), fmt.Sprintf("bit width must be a constant from 1 to %d", maxBits))
+			return nil, nil
+		}
+		flag, bits = WidthBit, n
+	}
+
+	if pad && p.tok != token.COMMA && p.tok != token.RBRACK {
+		p.error(p.pos, "padding cant have a type")
+		return nil, nil
+	}
+	if pad && !isbits {
+		if width == nil {
+			p.error(p.pos, "padding needs a width")
+			return nil, nil
+		}
+		p.parseWireType()
+		p.parseWireEndian()
+		typ := SliceOf(&ast.Ident{Name: "byte"})
+		info := &Info{Width: width, Endian: p.endian, Flag: flag, Pad: true}
+		p.parseWireOptions(info)
+		if info.Frame || info.Max != 0 {
+			p.error(p.pos, "padding cant be a frame size or have a maximum")
+			return nil, nil
+		}
+		p.expect(token.RBRACK)
+		return &ast.Field{Names: []*ast.Ident{name}, Type: typ}, info
+	}
+
+	if p.tok == token.SWITCH {
+		tag, ok := width.(*ast.Ident)
+		if !ok {
+			p.error(p.pos, "switch width must name the tag field")
+			return nil, nil
+		}
+		u := p.parseUnion()
+		u.Tag = tag
+		p.tryConsumeComma()
+		p.expect(token.RBRACK)
+		return &ast.Field{Names: []*ast.Ident{name}, Type: &ast.Ident{Name: "union"}},
+			&Info{Width: width, Endian: p.endian, Flag: flag, Union: u}
+	}
 
 	typ := p.parseWireType()
+	lit, _ := typ.(*ast.BasicLit)
+	if lit != nil {
+		if isbits {
+			p.error(lit.Pos(), fmt.Sprintf("bit field %s cant be a constant", label))
+			return nil, nil
+		}
+		if width == nil && lit.Kind == token.STRING {
+			if s, err := strconv.Unquote(lit.Value); err == nil {
+				width = &ast.BasicLit{Kind: token.INT, Value: strconv.Itoa(len(s))}
+				flag |= WidthLit
+			}
+		}
+		typ = SliceOf(&ast.Ident{Name: "byte"})
+	}
 	if width == nil && typ == nil {
 		p.error(p.pos, "width and type cannot both be empty")
 		return nil, nil
 	}
+	if at, ok := typ.(*ast.ArrayType); ok && at.Len != nil {
+		// An array's length is its width
+		n, ok := ConstWidth(at.Len)
+		if w, wok := ConstWidth(width); !ok || width != nil && (!wok || w != n) {
+			p.error(p.pos, fmt.Sprintf("field %s: width must be the array length", label))
+			return nil, nil
+		}
+		if width == nil {
+			width, flag = at.Len, flag|WidthLit
+		}
+	}
+	if t := typ; t != nil {
+		if at, ok := t.(*ast.ArrayType); ok {
+			t = at.Elt
+		}
+		if unsized[TypeString(t)] {
+			p.error(p.pos, fmt.Sprintf("field %s: type %s has no fixed width", label, TypeString(t)))
+			return nil, nil
+		}
+	}
+	if isbits {
+		if typ == nil {
+			typ = TypeFromBits(bits)
+		} else if max, ok := bitTypes[TypeString(typ)]; !ok || max < bits {
+			p.error(p.pos, fmt.Sprintf("bit field %s: type %s cant hold %d bits", label, TypeString(typ), bits))
+			return nil, nil
+		}
+	}
 	if typ == nil {
 		// check if 1,2,4,8, otherwise []byte
 		if p.trace {
@@ -147,9 +309,154 @@ func (p *parser) parseWireField() (*ast.Field, *Info) {
 		return nil, nil
 	}
 	endian := p.parseWireEndian()
+	if endian == nil {
+		endian = p.endian
+	}
+	info := &Info{Width: width, Endian: endian, Flag: flag, Bits: bits, Pad: pad}
+	p.parseWireOptions(info)
+	if isbits && info.Cond != nil {
+		p.error(info.Cond.Pos(), fmt.Sprintf("bit field %s cant be conditional", label))
+		return nil, nil
+	}
+	if info.Frame {
+		if _, ok := intTypes[TypeString(typ)]; !ok || isbits || info.Cond != nil {
+			p.error(p.pos, fmt.Sprintf("frame size %s must be an unconditional integer", label))
+			return nil, nil
+		}
+		if n, ok := ConstWidth(width); ok && n != numCodecs[TypeString(typ)].size {
+			p.error(p.pos, fmt.Sprintf("frame size %s must be 1, 2, 4 or 8 bytes", label))
+			return nil, nil
+		}
+	}
+	if lit != nil {
+		n, ok := ConstWidth(width)
+		if !ok || n < 1 {
+			p.error(p.pos, fmt.Sprintf("constant field %s needs a constant width", label))
+			return nil, nil
+		}
+		if _, err := constBytes(lit, n, endian); err != nil {
+			p.error(lit.Pos(), fmt.Sprintf("field %s: %v", label, err))
+			return nil, nil
+		}
+		info.Const = lit
+	}
+	if _, ok := ConstWidth(width); info.Max != 0 && (ok || !Slice(typ)) {
+		p.error(p.pos, fmt.Sprintf("field %s: max requires a slice with a variable width", label))
+		return nil, nil
+	}
+	p.expect(token.RBRACK)
+	return &ast.Field{Names: []*ast.Ident{name}, Type: typ}, info
+}
+
+// parseAlignField parses the remainder of a field padding the message
+// to a multiple of a constant number of bytes, as in align(4).
+func (p *parser) parseAlignField() (*ast.Field, *Info) {
+	if p.trace {
+		defer un(trace(p, "AlignField"))
+	}
+	p.expect(token.LPAREN)
+	x := p.parseExpr(false)
+	p.expect(token.RPAREN)
+	n, ok := ConstWidth(x)
+	if !ok || n < 1 {
+		p.error(x.Pos(), "alignment must be a positive constant")
+		return nil, nil
+	}
+	p.pads++
+	name := &ast.Ident{NamePos: x.Pos(), Name: fmt.Sprintf("_%d", p.pads)}
+	return &ast.Field{Names: []*ast.Ident{name}, Type: SliceOf(&ast.Ident{Name: "byte"})},
+		&Info{Endian: p.endian, Flag: WidthVar, Pad: true, Align: n}
+}
+
+// parseVarintField parses the remainder of a varint field, as in
+// n[uvarint]. The keyword takes the place of the width and selects
+// the type; varints have no byte order.
+func (p *parser) parseVarintField(name, kw *ast.Ident) (*ast.Field, *Info) {
+	if p.trace {
+		defer un(trace(p, "VarintField"))
+	}
+	if typ := p.parseWireType(); typ != nil {
+		p.error(typ.Pos(), fmt.Sprintf("varint field %s cant have a type", name.Name))
+		return nil, nil
+	}
+	if p.parseWireEndian() != nil {
+		p.error(p.pos, fmt.Sprintf("varint field %s has no byte order", name.Name))
+		return nil, nil
+	}
+	info := &Info{Endian: p.endian}
+	p.parseWireOptions(info)
+	if info.Frame || info.Max != 0 {
+		p.error(p.pos, fmt.Sprintf("varint field %s cant be a frame size or have a maximum", name.Name))
+		return nil, nil
+	}
 	p.expect(token.RBRACK)
-	return &ast.Field{Names: []*ast.Ident{name}, Type: typ},
-		&Info{Width: width, Endian: endian, Flag: flag}
+	return &ast.Field{Names: []*ast.Ident{name}, Type: varintTypes[kw.Name]}, info
+}
+
+// parseRestField parses the remainder of a field holding the rest of
+// the input, as in payload[*]. Its type must be []byte.
+func (p *parser) parseRestField(name *ast.Ident) (*ast.Field, *Info) {
+	if p.trace {
+		defer un(trace(p, "RestField"))
+	}
+	p.expect(token.MUL)
+	p.tryConsumeComma()
+	typ := p.parseWireType()
+	if typ == nil {
+		typ = SliceOf(&ast.Ident{Name: "byte"})
+	}
+	if !ByteSlice(typ) {
+		p.error(p.pos, fmt.Sprintf("field %s: rest of input must be []byte, not %s", name.Name, TypeString(typ)))
+		return nil, nil
+	}
+	p.parseWireEndian()
+	info := &Info{Endian: p.endian, Flag: WidthRest, Rest: true}
+	p.parseWireOptions(info)
+	p.expect(token.RBRACK)
+	return &ast.Field{Names: []*ast.Ident{name}, Type: typ}, info
+}
+
+// parseWireOptions parses the options following the endian of a field:
+//
+//	if expr		the field is present only if expr is true
+//	frame		the field holds the length of the whole message
+//	max=n		the field holds at most n elements
+func (p *parser) parseWireOptions(info *Info) {
+	if p.trace {
+		defer un(trace(p, "WireOptions"))
+	}
+	for p.tok != token.RBRACK && p.tok != token.EOF {
+		switch p.tok {
+		case token.IF:
+			p.next()
+			info.Cond = p.parseExpr(false)
+		case token.IDENT:
+			switch p.lit {
+			case "frame":
+				p.next()
+				info.Frame = true
+			case "max":
+				p.next()
+				p.expect(token.ASSIGN)
+				x := p.parseExpr(false)
+				n, ok := ConstWidth(x)
+				if !ok || n < 1 {
+					p.error(x.Pos(), "max must be a positive constant")
+					return
+				}
+				info.Max = n
+			default:
+				p.errorExpected(p.pos, "field option")
+				return
+			}
+		default:
+			p.errorExpected(p.pos, "field option")
+			return
+		}
+		if !p.tryConsumeComma() {
+			return
+		}
+	}
 }
 
 func (p *parser) tryConsumeComma() bool {
@@ -163,15 +470,21 @@ func (p *parser) tryConsumeComma() bool {
 	return false
 }
 
-func (p *parser) parseWireWidth() (x ast.Expr) {
+// parseWireWidth parses a width expression. A width followed by the
+// unit b, as in 4b, is a number of bits.
+func (p *parser) parseWireWidth() (x ast.Expr, bits bool) {
 	if p.trace {
 		defer un(trace(p, "WireWidth"))
 	}
 	if p.tok != token.COMMA {
 		x = p.parseExpr(false)
 	}
+	if p.tok == token.IDENT && p.lit == "b" {
+		p.next()
+		bits = true
+	}
 	p.tryConsumeComma()
-	return x
+	return x, bits
 }
 
 func (p *parser) parseWireType() (x ast.Expr) {
@@ -194,18 +507,118 @@ func (p *parser) parseWireEndian() binary.ByteOrder {
 		return nil
 	}
 	x := p.parseIdent()
-	switch {
-	case x == nil:
+	if x == nil {
 		p.error(p.pos, fmt.Sprintf("endian set to empty string"))
 		return nil
-	case x.Name == "BE":
+	}
+	e := EndianOf(x.Name)
+	if e == nil {
+		p.error(p.pos, fmt.Sprintf(`endian must be "LE" or "BE", got %q`, x.Name))
+	}
+	return e
+}
+
+// parseUnion parses the case list of a union field:
+//
+//	switch{1:Tversion, 2:Rversion, default:Raw}
+func (p *parser) parseUnion() *Union {
+	if p.trace {
+		defer un(trace(p, "Union"))
+	}
+	p.expect(token.SWITCH)
+	p.expect(token.LBRACE)
+	u := &Union{}
+	for p.tok != token.RBRACE && p.tok != token.EOF {
+		if p.tok == token.DEFAULT {
+			pos := p.pos
+			p.next()
+			p.expect(token.COLON)
+			if u.Default != nil {
+				p.error(pos, "multiple defaults in switch")
+			}
+			u.Default = p.parseTypeName()
+		} else {
+			v := p.parseExpr(false)
+			p.expect(token.COLON)
+			u.Cases = append(u.Cases, UnionCase{Value: v, Type: p.parseTypeName()})
+		}
+		if !p.tryConsumeComma() {
+			break
+		}
+	}
+	p.expect(token.RBRACE)
+	if err := u.check(); err != nil {
+		p.error(p.pos, err.Error())
+	}
+	return u
+}
+
+// checkRefs reports an error if an identifier in x does not name one of
+// the fields
+func (p *parser) checkRefs(fields []*ast.Field, x ast.Expr, what string) {
+	ast.Inspect(x, func(n ast.Node) bool {
+		id, ok := n.(*ast.Ident)
+		if !ok {
+			return true
+		}
+		for _, f := range fields {
+			if f != nil && f.Names[0].Name == id.Name {
+				return true
+			}
+		}
+		p.error(id.Pos(), fmt.Sprintf("%s %s is not a preceding field", what, id.Name))
+		return false
+	})
+}
+
+// checkConstRefs reports an error if x refers to a constant field, which
+// has no value in the struct
+func (p *parser) checkConstRefs(consts map[string]bool, x ast.Expr, what string) {
+	if x == nil {
+		return
+	}
+	ast.Inspect(x, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok && consts[id.Name] {
+			p.error(id.Pos(), fmt.Sprintf("%s %s is a constant field", what, id.Name))
+			return false
+		}
+		return true
+	})
+}
+
+// parseDefOptions parses the default byte and bit order of a
+// definition, given in angle brackets after the struct name, as in
+// Hdr<BE,LSB>.
+func (p *parser) parseDefOptions() {
+	if p.trace {
+		defer un(trace(p, "DefOptions"))
+	}
+	p.expect(token.LSS)
+	for {
+		x := p.parseIdent()
+		if e := EndianOf(x.Name); e != nil && x.Name != "" {
+			p.endian = e
+		} else if o, ok := BitOrderOf(x.Name); ok {
+			p.bitorder = o
+		} else {
+			p.error(x.Pos(), fmt.Sprintf(`expected "LE", "BE", "MSB" or "LSB" got %s`, x.Name))
+		}
+		if !p.tryConsumeComma() {
+			break
+		}
+	}
+	p.expect(token.GTR)
+}
+
+// EndianOf returns the byte order named by s, or nil if s is
+// not "LE", "BE" or empty.
+func EndianOf(s string) binary.ByteOrder {
+	switch s {
+	case "BE":
 		return binary.BigEndian
-	case x.Name == "LE":
-		return binary.LittleEndian
-	case x.Name == "":
+	case "LE", "":
 		return binary.LittleEndian
 	}
-	p.error(p.pos, fmt.Sprintf(`endian must be "LE" or "BE got %s"`, x.Name))
 	return nil
 }
 
@@ -293,7 +706,7 @@ func (p *parser) tryIdentOrLit() ast.Node {
 func (p *parser) tryIdentOrInt() ast.Expr {
 	switch p.tok {
 	case token.IDENT:
-		return p.parseIdent()
+		return p.parseTypeName()
 	case token.INT:
 		return p.parseRHS()
 	case token.LBRACK:
@@ -578,6 +991,15 @@ func (p *parser) parseOperand(lhs bool) ast.Expr {
 		x := &ast.BasicLit{ValuePos: p.pos, Kind: p.tok, Value: p.lit}
 		p.next()
 		return x
+
+	case token.LPAREN:
+		lparen := p.pos
+		p.next()
+		p.exprLev++
+		x := p.parseRHS()
+		p.exprLev--
+		rparen := p.expect(token.RPAREN)
+		return &ast.ParenExpr{Lparen: lparen, X: x, Rparen: rparen}
 	}
 
 	// we have an error
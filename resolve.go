@@ -1,10 +1,13 @@
 package wire9
 
 import (
+	"encoding/binary"
 	"fmt"
 	"go/ast"
+	"go/constant"
 	"go/token"
 	"go/types"
+	"strings"
 )
 
 type named struct {
@@ -31,7 +34,6 @@ func (c *cleaner) Visit(n ast.Node) ast.Visitor {
 		body := &ast.BlockStmt{List: make([]ast.Stmt, 0)}
 		for _, v := range t.Body.List {
 			switch v := v.(type) {
-			case *ast.ForStmt:
 			case *ast.BlockStmt:
 				var count counter
 				ast.Walk(&count, v)
@@ -58,6 +60,8 @@ func (c *counter) Visit(n ast.Node) ast.Visitor {
 		if t.Tok == token.DEFINE {
 			*c++
 		}
+	case *ast.DeclStmt:
+		*c++
 	case *ast.SwitchStmt, *ast.TypeSwitchStmt,
 		*ast.SelectStmt, *ast.IfStmt, *ast.ForStmt:
 		return nil
@@ -65,20 +69,13 @@ func (c *counter) Visit(n ast.Node) ast.Visitor {
 	return c
 }
 
-// Endian returns the endianness of a field. TODO: return
-// an error if the field can't have an endian value.
-func Endian(m ast.Node) (string, error) {
-	switch t := m.(type) {
-	case *ast.Ident:
-		switch t.Name{
-		case "BE": return "binary.BigEndian", nil
-		case "LE": return "binary.LittleEndian", nil
-		default:   return "", fmt.Errorf("invalid endian: %s", t.Name)
-		}
-	case nil:
-		return "binary.LittleEndian", nil
+// Endian returns the named field's byte order as a string. The
+// order is taken from the field's Info, and defaults to little-endian.
+func Endian(ts *ast.TypeSpec, f *ast.Field) string {
+	if info := TInfo.Get(ts, f); info != nil && info.Endian == binary.BigEndian {
+		return "binary.BigEndian"
 	}
-	return "binary.LittleEndian", nil
+	return "binary.LittleEndian"
 }
 
 func (n *named) Visit(node ast.Node) ast.Visitor {
@@ -93,6 +90,14 @@ func (n *named) Visit(node ast.Node) ast.Visitor {
 	case *ast.BasicLit:
 		n.string += string(t.Value)
 		return nil
+	case *ast.ParenExpr:
+		n.string += "("
+		n.Visit(t.X)
+		n.string += ")"
+		return nil
+	case *ast.UnaryExpr:
+		n.string += t.Op.String()
+		return n.Visit(t.X)
 	}
 	return nil
 }
@@ -107,7 +112,7 @@ func Access(a ast.Expr) string {
 		return types.ExprString(a)
 	case *ast.Ident:
 		return fmt.Sprintf("z.%s", types.ExprString(a))
-	case *ast.BinaryExpr, *ast.ArrayType, *ast.StarExpr:
+	case *ast.BinaryExpr, *ast.ArrayType, *ast.StarExpr, *ast.ParenExpr, *ast.UnaryExpr:
 		var n named
 		ast.Walk(&n, a)
 		return fmt.Sprintf("%s", n.string)
@@ -115,10 +120,96 @@ func Access(a ast.Expr) string {
 	return ""
 }
 
+// Cond returns the presence condition x as a boolean expression.
+// Expressions other than comparisons are true when non-zero.
+func Cond(ts *ast.TypeSpec, x ast.Expr) string {
+	switch t := x.(type) {
+	case *ast.ParenExpr:
+		return "(" + Cond(ts, t.X) + ")"
+	case *ast.UnaryExpr:
+		if t.Op == token.NOT {
+			return "!(" + Cond(ts, t.X) + ")"
+		}
+	case *ast.BinaryExpr:
+		switch t.Op {
+		case token.LAND, token.LOR:
+			return Cond(ts, t.X) + " " + t.Op.String() + " " + Cond(ts, t.Y)
+		case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ:
+			return Access(t)
+		}
+	case *ast.Ident:
+		if f := fieldNamed(ts, t.Name); f != nil && TypeString(f.Type) == "bool" {
+			return "z." + t.Name
+		}
+	}
+	return Access(x) + " != 0"
+}
+
+// CondOpen returns the opening of an if statement testing the
+// presence condition of f, or an empty string if f is unconditional
+func CondOpen(ts *ast.TypeSpec, f *ast.Field) string {
+	info := TInfo.Get(ts, f)
+	if info == nil || info.Cond == nil {
+		return ""
+	}
+	return "if " + Cond(ts, info.Cond) + " {"
+}
+
+// CondClose closes the if statement opened by CondOpen
+func CondClose(ts *ast.TypeSpec, f *ast.Field) string {
+	if CondOpen(ts, f) == "" {
+		return ""
+	}
+	return "}"
+}
+
+// VarWidth returns true if the width of f depends on other fields
+func VarWidth(ts *ast.TypeSpec, f *ast.Field) bool {
+	info := TInfo.Get(ts, f)
+	if info == nil || info.Width == nil {
+		return false
+	}
+	_, ok := ConstWidth(info.Width)
+	return !ok
+}
+
+// MaxLen returns the maximum number of elements in the slice f, or 0 if
+// it is unlimited. Fields with a constant width have no maximum; those
+// holding the rest of the input do.
+func MaxLen(ts *ast.TypeSpec, f *ast.Field) int {
+	info := TInfo.Get(ts, f)
+	if info == nil || !VarWidth(ts, f) && !info.Rest {
+		return 0
+	}
+	if info.Max != 0 {
+		return info.Max
+	}
+	return Options.MaxLen
+}
+
+// Rest returns true if f holds the rest of the input, as in payload[*]
+func Rest(ts *ast.TypeSpec, f *ast.Field) bool {
+	info := TInfo.Get(ts, f)
+	return info != nil && info.Rest
+}
+
+// fieldNamed returns the field of ts with the given name, or nil
+func fieldNamed(ts *ast.TypeSpec, name string) *ast.Field {
+	for _, f := range ts.Type.(*ast.StructType).Fields.List {
+		if f.Names[0].Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
 // TypeString returns the named field's type as a string
 func TypeString(f interface{}) string {
 	switch t := f.(type) {
 	case *ast.ArrayType:
+		if t.Len != nil {
+			return fmt.Sprintf("[%s]%s", types.ExprString(t.Len), TypeString(t.Elt))
+		}
 		return fmt.Sprintf("[]%s", TypeString(t.Elt))
 	case *ast.Ident:
 		return fmt.Sprint(t.Name)
@@ -146,7 +237,7 @@ func WidthOf(ts *ast.TypeSpec, f *ast.Field) (s string, err error) {
 		return s, nil
 	case *ast.Ident:
 		return fmt.Sprintf("int(z.%s)", s), nil
-	case *ast.BinaryExpr, *ast.ArrayType, *ast.StarExpr:
+	case *ast.BinaryExpr, *ast.ArrayType, *ast.StarExpr, *ast.ParenExpr, *ast.UnaryExpr:
 		s = Access(t)
 	}
 	if s == "" {
@@ -155,6 +246,163 @@ func WidthOf(ts *ast.TypeSpec, f *ast.Field) (s string, err error) {
 	return s, nil
 }
 
+//
+// Sizes
+
+// ConstWidth returns the value of the width expression x if it is
+// a constant
+func ConstWidth(x ast.Expr) (int, bool) {
+	if x == nil {
+		return 0, false
+	}
+	tv, err := types.Eval(token.NewFileSet(), nil, token.NoPos, types.ExprString(x))
+	if err != nil || tv.Value == nil {
+		return 0, false
+	}
+	n, ok := constant.Int64Val(constant.ToInt(tv.Value))
+	return int(n), ok
+}
+
+// StaticSize returns the binary width of the wire definition ts, or
+// -1 if the width depends on the value of its fields.
+func StaticSize(ts *ast.TypeSpec) int {
+	n, ok := staticSize(ts, make(map[string]bool))
+	if !ok {
+		return -1
+	}
+	return n
+}
+
+func staticSize(ts *ast.TypeSpec, seen map[string]bool) (size int, ok bool) {
+	if seen[ts.Name.Name] {
+		return 0, false
+	}
+	seen[ts.Name.Name] = true
+	defer delete(seen, ts.Name.Name)
+	for _, f := range ts.Type.(*ast.StructType).Fields.List {
+		info := TInfo.Get(ts, f)
+		if info != nil && (info.Cond != nil || info.Rest) {
+			return 0, false
+		}
+		if info != nil && info.Align != 0 {
+			size += alignPad(size, info.Align)
+			continue
+		}
+		n, ok := fieldSize(ts, f, seen)
+		if !ok {
+			return 0, false
+		}
+		size += n
+	}
+	return size, true
+}
+
+// fieldSize returns the binary width of f if it is constant
+func fieldSize(ts *ast.TypeSpec, f *ast.Field, seen map[string]bool) (int, bool) {
+	info := TInfo.Get(ts, f)
+	if info == nil {
+		return 0, false
+	}
+	if info.Bits != 0 {
+		// The group's width is counted at its first field
+		return info.Group, true
+	}
+	if info.Align != 0 {
+		// The width depends on the offset of the field
+		return 0, false
+	}
+	w, lit := ConstWidth(info.Width)
+	switch {
+	case NumSlice(f.Type):
+		return w * numCodecs[TypeString(f.Type.(*ast.ArrayType).Elt)].size, lit
+	case CustomSlice(f.Type), CustomArray(f.Type):
+		if !lit {
+			return 0, false
+		}
+		n, ok := typeSize(f.Type.(*ast.ArrayType).Elt, seen)
+		return n * w, ok
+	case lit:
+		return w, true
+	}
+	return typeSize(f.Type, seen)
+}
+
+// typeSize returns the binary width of the type x if it is constant
+func typeSize(x ast.Expr, seen map[string]bool) (int, bool) {
+	if c, ok := numCodecs[TypeString(x)]; ok {
+		return c.size, true
+	}
+	id, ok := x.(*ast.Ident)
+	if !ok {
+		return 0, false
+	}
+	ts, ok := TInfo.Nstructs[id.Name]
+	if !ok {
+		return 0, false
+	}
+	return staticSize(&ts, seen)
+}
+
+// SizeOf returns a statement adding the binary width of the named
+// field to n
+func SizeOf(ts *ast.TypeSpec, f *ast.Field) (string, error) {
+	s, err := sizeOf(ts, f)
+	if err != nil || CondOpen(ts, f) == "" {
+		return s, err
+	}
+	return CondOpen(ts, f) + "\n" + s + "\n}", nil
+}
+
+func sizeOf(ts *ast.TypeSpec, f *ast.Field) (string, error) {
+	if n, ok := fieldSize(ts, f, make(map[string]bool)); ok {
+		return fmt.Sprintf("n += %d", n), nil
+	}
+	name := "z." + f.Names[0].Name
+	switch info := TInfo.Get(ts, f); {
+	case info != nil && info.Align != 0:
+		return fmt.Sprintf("n += (%d - n%%%d) %% %d", info.Align, info.Align, info.Align), nil
+	case Rest(ts, f):
+		return fmt.Sprintf("n += len(%s)", name), nil
+	case IsUnion(ts, f):
+		return fmt.Sprintf("if %s != nil { n += %s.BinarySize() }", name, name), nil
+	case CustomSlice(f.Type):
+		w, err := WidthOf(ts, f)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("for i, x := 0, %s; i < x && i < len(%s); i++ { %s }", w, name, elemSize(f, name+"[i]")), nil
+	case CustomArray(f.Type):
+		return fmt.Sprintf("for i := range %s { %s }", name, elemSize(f, name+"[i]")), nil
+	case NumSlice(f.Type):
+		w, err := WidthOf(ts, f)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("n += %s * %d", w, numCodecs[TypeString(f.Type.(*ast.ArrayType).Elt)].size), nil
+	case Slice(f.Type):
+		w, err := WidthOf(ts, f)
+		if err != nil {
+			return "", err
+		}
+		return "n += " + w, nil
+	case Custom(f.Type):
+		if _, ok := f.Type.(*ast.StarExpr); ok {
+			return fmt.Sprintf("if %s != nil { n += %s.BinarySize() }", name, name), nil
+		}
+		return "n += " + name + ".BinarySize()", nil
+	}
+	return "", fmt.Errorf("%s: cant determine binary size", f.Names[0].Name)
+}
+
+// elemSize returns the statement adding the size of x, an element of
+// the slice or array f, to n. Nil pointer elements add nothing.
+func elemSize(f *ast.Field, x string) string {
+	if _, ok := f.Type.(*ast.ArrayType).Elt.(*ast.StarExpr); ok {
+		return fmt.Sprintf("if %s != nil { n += %s.BinarySize() }", x, x)
+	}
+	return fmt.Sprintf("n += %s.BinarySize()", x)
+}
+
 //
 // Loop detection
 
@@ -216,6 +464,38 @@ func CustomSlice(f ast.Expr) (ok bool) {
 	return Slice(f) && Custom(f.(*ast.ArrayType).Elt)
 }
 
+// CustomArray returns true if f is an array of a type that is not
+// builtin
+func CustomArray(f ast.Expr) (ok bool) {
+	defer func() { recover() }()
+	return Array(f) && Custom(f.(*ast.ArrayType).Elt)
+}
+
+// NilCheck returns the statement guarding x against nil if f, or the
+// element type of f, is a pointer. Read and Decode allocate a value for
+// x, and Write and Append return an error.
+func NilCheck(ts *ast.TypeSpec, f *ast.Field, x, method string) string {
+	t := f.Type
+	if at, ok := t.(*ast.ArrayType); ok {
+		t = at.Elt
+	}
+	p, ok := t.(*ast.StarExpr)
+	if !ok {
+		return ""
+	}
+	ret, where, args := "return", ts.Name.Name+"."+f.Names[0].Name, ""
+	switch method {
+	case "Read", "Decode":
+		return fmt.Sprintf("if %s == nil { %s = new(%s) }", x, x, TypeString(p.X))
+	case "Append":
+		ret = "return b,"
+	}
+	if strings.HasSuffix(x, "[i]") {
+		where, args = where+"[%d]", ", i"
+	}
+	return fmt.Sprintf("if %s == nil { %s fmt.Errorf(\"%s: nil %s\"%s) }", x, ret, where, TypeString(p), args)
+}
+
 // Literal returns true if f is not a builtin type, and is a slice
 func Literal(f ast.Expr) (ok bool) {
 	defer func() { recover() }()
@@ -229,12 +509,26 @@ func Numeric(f ast.Expr) (ok bool) {
 	return builtin[TypeString(f)] && !String(f) && Coherent(f)
 }
 
+// NumSlice returns true if f is a slice or array of a builtin numeric
+// type other than a byte slice
+func NumSlice(f ast.Expr) (ok bool) {
+	defer func() { recover() }()
+	_, ok = numCodecs[TypeString(f.(*ast.ArrayType).Elt)]
+	return ok && !ByteSlice(f)
+}
+
 // Slice returns true if f is a slice type
 func Slice(f ast.Expr) (ok bool) {
 	defer func() { recover() }()
 	return f.(*ast.ArrayType).Len == nil
 }
 
+// Varint returns true if f is one of the integer types in package varint
+func Varint(f ast.Expr) bool {
+	_, ok := varintMax[TypeString(f)]
+	return ok
+}
+
 // Selector returns the syntax for selecting an element of f. If f
 // is not an array or slice, the field is returned as is.
 func Selector(f ast.Expr) string {
@@ -258,3 +552,41 @@ func String(f ast.Expr) (ok bool) {
 	defer func() { recover() }()
 	return f.(*ast.Ident).Name == "string"
 }
+
+//
+// Slice codec
+
+// NumSize returns the binary width of the numeric field f
+func NumSize(f *ast.Field) (int, error) {
+	c, ok := numCodecs[TypeString(f.Type)]
+	if !ok {
+		return 0, fmt.Errorf("no slice codec for type: %s", TypeString(f.Type))
+	}
+	return c.size, nil
+}
+
+// DecodeNum returns a statement decoding the numeric field f from b[n:]
+func DecodeNum(ts *ast.TypeSpec, f *ast.Field) (string, error) {
+	typ := TypeString(f.Type)
+	c, ok := numCodecs[typ]
+	if !ok {
+		return "", fmt.Errorf("no slice codec for type: %s", typ)
+	}
+	return "z." + f.Names[0].Name + " = " + expand(c.get, Endian(ts, f), "n", "", typ), nil
+}
+
+// AppendNum returns a statement appending the numeric field f to b
+func AppendNum(ts *ast.TypeSpec, f *ast.Field) (string, error) {
+	typ := TypeString(f.Type)
+	c, ok := numCodecs[typ]
+	if !ok {
+		return "", fmt.Errorf("no slice codec for type: %s", typ)
+	}
+	return expand(c.put, Endian(ts, f), "", "z."+f.Names[0].Name, typ), nil
+}
+
+// expand substitutes the byte order, index, value and type into a
+// numCodec template
+func expand(s, order, at, v, typ string) string {
+	return strings.NewReplacer("$order", order, "$at", at, "$v", v, "$type", typ).Replace(s)
+}
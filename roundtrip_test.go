@@ -0,0 +1,897 @@
+package wire9
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// rtPrelude is shared by the programs built with runWire. The rt function
+// writes in, prints the encoding in hex, reads it back into out, and prints
+// out.
+const rtPrelude = `package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"testing/iotest"
+
+	"github.com/as/wire9/wire"
+)
+
+type codec interface {
+	ReadBinary(io.Reader) error
+	WriteBinary(io.Writer) error
+	AppendBinary([]byte) ([]byte, error)
+	DecodeFrom([]byte) (int, error)
+}
+
+func rt(in, out codec) {
+	var buf bytes.Buffer
+	if err := in.WriteBinary(&buf); err != nil {
+		fmt.Println("write:", err)
+		return
+	}
+	b, err := in.AppendBinary(nil)
+	if err != nil || !bytes.Equal(b, buf.Bytes()) {
+		fmt.Printf("append: %x %v\n", b, err)
+		return
+	}
+	fmt.Printf("%x ", buf.Bytes())
+	if err := out.ReadBinary(&buf); err != nil {
+		fmt.Println("read:", err)
+		return
+	}
+	if n, err := out.DecodeFrom(b); err != nil || n != len(b) {
+		fmt.Println("decode:", n, err)
+		return
+	}
+	fmt.Printf("%v\n", out)
+}
+
+var (
+	_ = errors.New
+	_ = io.EOF
+	_ = iotest.OneByteReader
+	_ = runtime.GC
+	_ = strings.NewReader
+	_ = wire.Annotate
+)
+`
+
+// runWire generates code for the wire definitions in src, builds it
+// together with src as a main package, and returns the program's output.
+func runWire(t *testing.T, src string) string {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("skipping compiled round trip in short mode")
+	}
+	gobin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+	root, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	gopath := t.TempDir()
+	dir := filepath.Join(gopath, "src", "wire9rt")
+	if err := os.MkdirAll(filepath.Join(gopath, "src", "github.com", "as"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(root, filepath.Join(gopath, "src", "github.com", "as", "wire9")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	main := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(main, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(sc bool) { Options.SliceCodec = sc }(Options.SliceCodec)
+	Options.SliceCodec = true
+	data, err := FromFiles([]string{main}, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "main_wire9.go"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(gobin, "run", ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOPATH="+gopath, "GO111MODULE=off", "GOFLAGS=")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("go run: %s\n%s\n%s", err, out, data)
+	}
+	return string(out)
+}
+
+func ckOutput(t *testing.T, have, want string) {
+	t.Helper()
+	have, want = strings.TrimSpace(have), strings.TrimSpace(want)
+	if have != want {
+		t.Errorf("output mismatch\nhave:\n%s\nwant:\n%s", have, want)
+	}
+}
+
+func TestRoundTripMarshal(t *testing.T) {
+	out := runWire(t, rtPrelude+`
+//wire9 Pair a[2] n[1] data[n]
+
+func main() {
+	p := Pair{a: 0x102, n: 2, data: []byte("hi")}
+	b, err := p.MarshalBinary()
+	fmt.Printf("%x %v\n", b, err)
+	var q Pair
+	fmt.Println(q.UnmarshalBinary(b), q)
+	fmt.Println(q.UnmarshalBinary(append(b, 0, 0)))
+}
+`)
+	ckOutput(t, out, `
+0201026869 <nil>
+<nil> {258 2 [104 105]}
+Pair: UnmarshalBinary: 2 trailing bytes
+`)
+}
+
+func TestRoundTripSliceCodec(t *testing.T) {
+	out := runWire(t, rtPrelude+`
+//wire9 Msg kind[2,,BE] seq[4] f[8,float64] n[1] data[n]
+
+func main() {
+	m := Msg{kind: 0x102, seq: 7, f: 1.5, n: 2, data: []byte("hi")}
+	b, err := m.AppendBinary([]byte("pre"))
+	fmt.Printf("%s %x %v\n", b[:3], b[3:], err)
+
+	buf := make([]byte, 8)
+	d := Msg{data: buf[:0]}
+	n, err := d.DecodeFrom(append(b[3:], 0xff))
+	fmt.Println(n, err, d, &d.data[0] == &buf[0])
+	_, err = d.DecodeFrom(b[3:7])
+	fmt.Println(err)
+}
+`)
+	ckOutput(t, out, `
+pre 010207000000000000000000f83f026869 <nil>
+17 <nil> {258 7 1.5 2 [104 105]} true
+Msg.seq at offset 2: short read: 2/4 bytes: unexpected EOF
+`)
+}
+
+func TestRoundTripBinarySize(t *testing.T) {
+	out := runWire(t, rtPrelude+`
+//wire9 Pt x[4] y[4]
+//wire9 Line a[,Pt] b[,Pt] tag[1]
+//wire9 Poly n[2] pts[n,[]Pt] edge[,Line]
+//wire9 Str n[1] data[n]
+//wire9 Nest s[,Str] p[,Pt]
+//wire9 Cnt n[uvarint] data[n] m[2] strs[m,[]Str] k[1] vals[k,[]uint16]
+
+func main() {
+	fmt.Println(PtSize, LineSize)
+	poly := Poly{n: 3, pts: make([]Pt, 3)}
+	b, _ := poly.MarshalBinary()
+	fmt.Println(poly.BinarySize(), len(b))
+	nest := Nest{s: Str{n: 5, data: []byte("hello")}}
+	b, _ = nest.MarshalBinary()
+	fmt.Println(nest.BinarySize(), len(b))
+	cnt := Cnt{data: make([]byte, 200), strs: []Str{{data: []byte("hi")}}, vals: []uint16{1, 2}}
+	b, _ = cnt.MarshalBinary()
+	fmt.Println(cnt.BinarySize(), len(b), cnt.n, cnt.m)
+}
+`)
+	ckOutput(t, out, `
+8 17
+43 43
+14 14
+212 212 0 0
+`)
+}
+
+func TestRoundTripPointer(t *testing.T) {
+	out := runWire(t, rtPrelude+`
+//wire9 Str n[1] data[n]
+//wire9 P s[,*Str] x[1]
+
+func main() {
+	b, err := P{s: &Str{n: 2, data: []byte("hi")}, x: 7}.AppendBinary(nil)
+	fmt.Printf("%x %v\n", b, err)
+	var q, d P
+	fmt.Println(q.UnmarshalBinary(b), *q.s, q.x)
+	n, err := d.DecodeFrom(b)
+	fmt.Println(n, err, *d.s, d.x)
+
+	p := P{x: 7}
+	fmt.Println(p.BinarySize(), p.WriteBinary(new(bytes.Buffer)))
+	_, err = p.AppendBinary(nil)
+	fmt.Println(err)
+}
+`)
+	ckOutput(t, out, `
+02686907 <nil>
+<nil> {2 [104 105]} 7
+4 <nil> {2 [104 105]} 7
+1 P.s: nil *Str
+P.s: nil *Str
+`)
+}
+
+func TestRoundTripPointerElems(t *testing.T) {
+	out := runWire(t, rtPrelude+`
+//wire9 Str n[1] data[n]
+//wire9 L n[1] items[n,[]*Str] pair[2,[2]*Str]
+
+func main() {
+	s := func(x string) *Str { return &Str{n: uint8(len(x)), data: []byte(x)} }
+	b, err := L{items: []*Str{s("a"), s("bc")}, pair: [2]*Str{s("d"), s("")}}.AppendBinary(nil)
+	fmt.Printf("%x %v\n", b, err)
+	var q, d L
+	fmt.Println(q.UnmarshalBinary(b), *q.items[0], *q.items[1], *q.pair[0], *q.pair[1])
+	n, err := d.DecodeFrom(b)
+	fmt.Println(n, err, *d.items[0], *d.items[1], *d.pair[0], *d.pair[1])
+
+	l := L{n: 2, items: []*Str{s("a"), nil}, pair: [2]*Str{s("d"), s("e")}}
+	fmt.Println(l.BinarySize(), l.WriteBinary(new(bytes.Buffer)))
+	l.items[1] = s("b")
+	l.pair[1] = nil
+	_, err = l.AppendBinary(nil)
+	fmt.Println(err)
+}
+`)
+	ckOutput(t, out, `
+020161026263016400 <nil>
+<nil> {1 [97]} {2 [98 99]} {1 [100]} {0 []}
+9 <nil> {1 [97]} {2 [98 99]} {1 [100]} {0 []}
+7 L.items[1]: nil *Str
+L.pair[1]: nil *Str
+`)
+}
+
+func TestRoundTripEndian(t *testing.T) {
+	out := runWire(t, rtPrelude+`
+//wire9 LE16 x[2]
+//wire9 BE16 x[2,,BE]
+//wire9 LE32 x[4,,LE]
+//wire9 BE32 x[4,uint32,BE]
+//wire9 BE64 x[8,int64,BE]
+//wire9 BEF64 x[8,float64,BE]
+//wire9 Mixed a[2,,BE] b[2] c[4,,BE] d[4]
+//wire9 Pstr n[1] data[n]
+//wire9 ApeStr n[2,uint16,BE] data[n,[]Pstr]
+
+func main() {
+	rt(&LE16{0x0102}, new(LE16))
+	rt(&BE16{0x0102}, new(BE16))
+	rt(&LE32{0x01020304}, new(LE32))
+	rt(&BE32{0x01020304}, new(BE32))
+	rt(&BE64{-2}, new(BE64))
+	rt(&BEF64{1}, new(BEF64))
+	rt(&Mixed{1, 1, 1, 1}, new(Mixed))
+	rt(&ApeStr{2, []Pstr{{2, []byte("hi")}, {1, []byte("!")}}}, new(ApeStr))
+}
+`)
+	ckOutput(t, out, `
+0201 &{258}
+0102 &{258}
+04030201 &{16909060}
+01020304 &{16909060}
+fffffffffffffffe &{-2}
+3ff0000000000000 &{1}
+000101000000000101000000 &{1 1 1 1}
+00020268690121 &{2 [{2 [104 105]} {1 [33]}]}
+`)
+}
+
+func TestRoundTripEndianDirective(t *testing.T) {
+	out := runWire(t, rtPrelude+`
+//wire9 A x[2] y[2,,BE]
+//wire9:endian BE
+//wire9 B x[2] y[2,,LE]
+//wire9 C<LE> x[2] y[2,,BE]
+//wire9:endian LE
+//wire9 D x[2]
+//wire9 E<BE> x[2]
+
+func main() {
+	rt(&A{1, 1}, new(A))
+	rt(&B{1, 1}, new(B))
+	rt(&C{1, 1}, new(C))
+	rt(&D{1}, new(D))
+	rt(&E{1}, new(E))
+}
+`)
+	ckOutput(t, out, `
+01000001 &{1 1}
+00010100 &{1 1}
+01000001 &{1 1}
+0100 &{1}
+0001 &{1}
+`)
+}
+
+func TestRoundTripBits(t *testing.T) {
+	out := runWire(t, rtPrelude+`
+//wire9 IPv4 version[4b] ihl[4b] dscp[6b] ecn[2b] length[2,,BE] flags[3b] frag[13b] ttl[1]
+//wire9 Flags<LSB> a[1b,bool] b[3b] c[4b] d[12b] e[4b]
+//wire9:bitorder LSB
+//wire9 Wide<MSB> a[12b] b[20b,uint32] c[1]
+//wire9 Low a[4b] b[4b]
+
+func main() {
+	rt(&IPv4{4, 5, 0x2e, 1, 20, 2, 0x1234, 64}, new(IPv4))
+	rt(&Flags{true, 5, 0xa, 0xfed, 3}, new(Flags))
+	rt(&Wide{0xabc, 0x12345, 7}, new(Wide))
+	rt(&Low{1, 2}, new(Low))
+	fmt.Println(IPv4Size, FlagsSize, WideSize, LowSize)
+}
+`)
+	ckOutput(t, out, `
+45b90014523440 &{4 5 46 1 20 2 4660 64}
+abed3f &{true 5 10 4077 3}
+abc1234507 &{2748 74565 7}
+21 &{1 2}
+7 3 5 1
+`)
+}
+
+func TestRoundTripUnion(t *testing.T) {
+	out := runWire(t, rtPrelude+`
+//wire9 Tversion msize[4] n[2] version[n]
+//wire9 Rversion msize[4]
+//wire9 Raw n[1] data[n]
+//wire9 Msg kind[1] body[kind, switch{1:Tversion, 2:Rversion, default:Raw}]
+//wire9 Strict kind[1] body[kind, switch{'a':Rversion, 'b':Raw, 'c':Raw}]
+
+func union(in, out codec, body func() interface{}) {
+	b, err := in.AppendBinary(nil)
+	if err != nil {
+		fmt.Println("append:", err)
+		return
+	}
+	var buf bytes.Buffer
+	if err := in.WriteBinary(&buf); err != nil || !bytes.Equal(buf.Bytes(), b) {
+		fmt.Printf("write: %x %v\n", buf.Bytes(), err)
+		return
+	}
+	if err := out.ReadBinary(&buf); err != nil {
+		fmt.Println("read:", err)
+		return
+	}
+	fmt.Printf("%x %T %v\n", b, body(), body())
+}
+
+func main() {
+	var m Msg
+	union(&Msg{body: &Tversion{8192, 2, []byte("9P")}}, &m, func() interface{} { return m.body })
+	union(&Msg{body: &Rversion{1}}, &m, func() interface{} { return m.body })
+	union(&Msg{kind: 7, body: &Raw{1, []byte("x")}}, &m, func() interface{} { return m.body })
+	union(&Msg{kind: 1, body: &Raw{1, []byte("x")}}, &m, func() interface{} { return m.body })
+	union(&Msg{}, &m, func() interface{} { return m.body })
+
+	var s Strict
+	union(&Strict{kind: 'c', body: &Raw{1, []byte("x")}}, &s, func() interface{} { return s.body })
+	union(&Strict{kind: 'a', body: &Raw{1, []byte("x")}}, &s, func() interface{} { return s.body })
+	union(&Strict{body: &Rversion{2}}, &s, func() interface{} { return s.body })
+	fmt.Println(s.UnmarshalBinary([]byte{9}))
+}
+`)
+	ckOutput(t, out, `
+010020000002003950 *main.Tversion &{8192 2 [57 80]}
+0201000000 *main.Rversion &{1}
+070178 *main.Raw &{1 [120]}
+append: Msg.body: *main.Raw with kind 1
+append: Msg.body: nil
+630178 *main.Raw &{1 [120]}
+append: Strict.body: *main.Raw with kind 97
+6102000000 *main.Rversion &{2}
+Strict.body at offset 1: unknown kind: 9
+`)
+}
+
+func TestRoundTripCond(t *testing.T) {
+	out := runWire(t, rtPrelude+`
+//wire9 Opt flags[1] ext[4,,BE,if flags&0x80] n[1,,,if flags&0x01 != 0] data[n,,,if (flags&0x01 == 1)] end[1]
+//wire9 Both a[1,bool] b[1] c[2,,,if a && b > 2 || !(b != 9)]
+//wire9 Nest flags[1] opt[,Opt,,if flags == 'x']
+
+func main() {
+	rt(&Opt{flags: 0x80, ext: 0x01020304, end: 9}, new(Opt))
+	rt(&Opt{flags: 0x01, n: 2, data: []byte("hi"), end: 9}, new(Opt))
+	rt(&Opt{flags: 0x81, ext: 7, n: 1, data: []byte("!"), end: 9}, new(Opt))
+	rt(&Opt{ext: 7, n: 3, end: 9}, new(Opt))
+	rt(&Both{true, 3, 1}, new(Both))
+	rt(&Both{false, 3, 1}, new(Both))
+	rt(&Both{false, 9, 1}, new(Both))
+	rt(&Nest{'x', Opt{flags: 0x80, ext: 1, end: 2}}, new(Nest))
+	rt(&Nest{'y', Opt{flags: 0x80, ext: 1, end: 2}}, new(Nest))
+	fmt.Println(Opt{flags: 0x81, data: []byte("abc")}.BinarySize(), Opt{}.BinarySize())
+}
+`)
+	ckOutput(t, out, `
+800102030409 &{128 16909060 0 [] 9}
+0102686909 &{1 0 2 [104 105] 9}
+8100000007012109 &{129 7 1 [33] 9}
+0009 &{0 0 0 [] 9}
+01030100 &{true 3 1}
+0003 &{false 3 0}
+00090100 &{false 9 1}
+78800000000102 &{120 {128 1 0 [] 2}}
+79 &{121 {0 0 0 [] 0}}
+10 2
+`)
+}
+
+func TestRoundTripRest(t *testing.T) {
+	out := runWire(t, rtPrelude+`
+//wire9 Tail kind[1] payload[*]
+//wire9 Frame size[4,,BE] kind[4] payload[size-8]
+//wire9 Outer hdr[,Frame] trailer[*,[]byte]
+
+func main() {
+	rt(&Tail{1, []byte("hello")}, new(Tail))
+	rt(&Tail{2, nil}, new(Tail))
+	rt(&Frame{11, 2, []byte("abc")}, new(Frame))
+	rt(&Outer{Frame{9, 0, []byte("x")}, []byte("yz")}, new(Outer))
+	fmt.Println(Tail{payload: []byte("hi")}.BinarySize())
+	var f Frame
+	_, err := f.DecodeFrom([]byte{0, 0, 0, 4, 0, 0, 0, 0})
+	fmt.Println(err)
+}
+`)
+	ckOutput(t, out, `
+0168656c6c6f &{1 [104 101 108 108 111]}
+02 &{2 []}
+0000000b02000000616263 &{11 2 [97 98 99]}
+000000090000000078797a &{{9 0 [120]} [121 122]}
+3
+Frame.payload at offset 8: negative width -4
+`)
+}
+
+func TestRoundTripFrame(t *testing.T) {
+	out := runWire(t, rtPrelude+`
+//wire9 Rmsg size[4,,,frame] kind[1] tag[2] payload[*]
+//wire9 Tagged magic[2,,BE] size[2,,BE,frame] data[3,[]byte]
+//wire9 Pair a[,Rmsg] b[,Rmsg]
+
+func main() {
+	rt(&Rmsg{kind: 100, tag: 1, payload: []byte("abc")}, new(Rmsg))
+	rt(&Tagged{magic: 0xcafe, data: []byte("xyz")}, new(Tagged))
+	rt(&Pair{Rmsg{kind: 1}, Rmsg{kind: 2, payload: []byte("!")}}, new(Pair))
+
+	var m Rmsg
+	fmt.Println(m.UnmarshalBinary([]byte{3, 0, 0, 0}))
+	_, err := m.DecodeFrom([]byte{3, 0, 0, 0})
+	fmt.Println(err)
+	_, err = m.DecodeFrom([]byte{9, 0, 0, 0, 1, 2, 0})
+	fmt.Println(err)
+	var g Tagged
+	fmt.Println(g.UnmarshalBinary([]byte{0xca, 0xfe, 0, 9, 1, 2, 3, 4, 5}))
+	_, err = g.DecodeFrom([]byte{0xca, 0xfe, 0, 9, 1, 2, 3, 4, 5})
+	fmt.Println(err)
+	_, err = g.DecodeFrom([]byte{0xca, 0xfe, 0, 6, 1, 2, 3})
+	fmt.Println(err)
+}
+`)
+	ckOutput(t, out, `
+0a000000640100616263 &{10 100 1 [97 98 99]}
+cafe000778797a &{51966 7 [120 121 122]}
+070000000100000800000002000021 &{{7 1 0 []} {8 2 0 [33]}}
+Rmsg.size at offset 0: frame size 3 is less than 4
+Rmsg.size at offset 0: frame size 3 is less than 4
+Rmsg.size at offset 0: short frame: 7/9 bytes: unexpected EOF
+Tagged.size at offset 2: 2 unread bytes in frame
+Tagged.size at offset 2: 2 unread bytes in frame
+Tagged.data at offset 4: short read: 2/3 bytes: unexpected EOF
+`)
+}
+
+func TestRoundTripLengths(t *testing.T) {
+	out := runWire(t, rtPrelude+`
+//wire9 Str n[1] data[n]
+//wire9 Pair n[2] keys[n] vals[n,[]Str]
+//wire9 Nib n[4b] m[4b] a[n] b[m]
+//wire9 Opt flags[1] n[1] data[n,,,if flags != 0]
+//wire9 C flags[1] n[1,,,if flags&1] data[n]
+
+func main() {
+	rt(&Str{data: []byte("hello")}, new(Str))
+	rt(&Str{n: 9, data: []byte("hi")}, new(Str))
+	rt(&Pair{keys: []byte("ab"), vals: []Str{{data: []byte("x")}, {}}}, new(Pair))
+	rt(&Pair{keys: []byte("ab"), vals: []Str{{}}}, new(Pair))
+	rt(&Nib{a: []byte("abc"), b: []byte("d")}, new(Nib))
+	rt(&Nib{a: make([]byte, 16)}, new(Nib))
+	rt(&Str{data: make([]byte, 256)}, new(Str))
+	rt(&Opt{flags: 1, data: []byte("z")}, new(Opt))
+	rt(&Opt{n: 2}, new(Opt))
+
+	s := Str{data: []byte("abc")}
+	fmt.Println(s.SetLengths(), s.n)
+	rt(&C{flags: 1, data: []byte("abc")}, new(C))
+	rt(&C{}, new(C))
+	rt(&C{data: []byte("abc")}, new(C))
+}
+`)
+	ckOutput(t, out, `
+0568656c6c6f &{5 [104 101 108 108 111]}
+026869 &{2 [104 105]}
+02006162017800 &{2 [97 98] [{1 [120]} {0 []}]}
+write: Pair.vals: length 1 differs from 2 of the other slices counted by n
+3161626364 &{3 1 [97 98 99] [100]}
+write: Nib.n: length 16 is too large
+write: Str.n: length 256 is too large
+01017a &{1 1 [122]}
+0002 &{0 2 []}
+<nil> 3
+0103616263 &{1 3 [97 98 99]}
+00 &{0 0 []}
+write: C.n: count is absent but its slices hold 3 elements
+`)
+}
+
+func TestRoundTripDecodeError(t *testing.T) {
+	out := runWire(t, rtPrelude+`
+//wire9 Str n[1] data[n]
+//wire9 Hdr kind[1] tag[2]
+//wire9 Msg hdr[,Hdr] n[1] strs[n,[]Str]
+//wire9 Fixed data[5,[]byte]
+
+func decode(b []byte) {
+	var m Msg
+	_, err := m.DecodeFrom(b)
+	rerr := m.ReadBinary(bytes.NewReader(b))
+	var de *wire.DecodeError
+	if errors.As(err, &de) {
+		fmt.Println(de.Type, de.Field, de.Offset, errors.Is(de, io.ErrUnexpectedEOF), err.Error() == rerr.Error())
+	} else {
+		fmt.Println(err, rerr)
+	}
+}
+
+func main() {
+	decode([]byte{1, 2})
+	decode([]byte{1, 2, 0, 2, 1, 'a', 3, 'b'})
+	decode([]byte{1, 2, 0, 1})
+	decode(nil)
+
+	var f Fixed
+	fmt.Println(f.ReadBinary(bytes.NewReader(nil)) == io.EOF)
+	fmt.Println(new(Fixed).WriteBinary(new(bytes.Buffer)))
+	_, err := Fixed{[]byte("ab")}.MarshalBinary()
+	fmt.Println(err)
+}
+`)
+	ckOutput(t, out, `
+Hdr tag 1 true true
+Str data 7 true true
+Str n 4 true false
+Hdr kind 0 true false
+true
+Fixed.data: have 0 bytes, want 5
+Fixed.data: have 2 bytes, want 5
+`)
+}
+
+func TestRoundTripShortRead(t *testing.T) {
+	out := runWire(t, rtPrelude+`
+//wire9 Str n[1] data[n]
+//wire9 Msg kind[2,,BE] v[4b] f[4b] n[1] strs[n,[]Str] tail[5,[]byte]
+
+func read(name string, r io.Reader) {
+	var m Msg
+	err := m.ReadBinary(r)
+	fmt.Println(name, err, errors.Is(err, io.ErrUnexpectedEOF), m)
+}
+
+func main() {
+	in := Msg{kind: 0x102, v: 3, f: 4, strs: []Str{{data: []byte("abc")}, {data: []byte("d")}}, tail: []byte("vwxyz")}
+	b, err := in.MarshalBinary()
+	if err != nil {
+		panic(err)
+	}
+	read("one", iotest.OneByteReader(bytes.NewReader(b)))
+	read("half", iotest.HalfReader(bytes.NewReader(b)))
+	read("dataerr", iotest.DataErrReader(bytes.NewReader(b)))
+	for _, n := range []int{0, 1, 2, 3, 4, 6, 10} {
+		read(fmt.Sprint(n), iotest.HalfReader(bytes.NewReader(b[:n])))
+	}
+}
+`)
+	ckOutput(t, out, `
+one <nil> false {258 3 4 2 [{3 [97 98 99]} {1 [100]}] [118 119 120 121 122]}
+half <nil> false {258 3 4 2 [{3 [97 98 99]} {1 [100]}] [118 119 120 121 122]}
+dataerr <nil> false {258 3 4 2 [{3 [97 98 99]} {1 [100]}] [118 119 120 121 122]}
+0 EOF false {0 0 0 0 [] []}
+1 Msg.kind at offset 0: short read: 1/2 bytes: unexpected EOF true {0 0 0 0 [] []}
+2 Msg.v at offset 2: short read: 0/1 bytes: unexpected EOF true {258 0 0 0 [] []}
+3 Msg.n at offset 3: short read: 0/1 bytes: unexpected EOF true {258 3 4 0 [] []}
+4 Msg.strs at offset 4: unexpected EOF true {258 3 4 2 [] []}
+6 Str.data at offset 5: short read: 1/3 bytes: unexpected EOF true {258 3 4 2 [] []}
+10 Msg.tail at offset 10: short read: 0/5 bytes: unexpected EOF true {258 3 4 2 [{3 [97 98 99]} {1 [100]}] []}
+`)
+}
+
+func TestRoundTripMaxLen(t *testing.T) {
+	defer func(n int) { Options.MaxLen = n }(Options.MaxLen)
+	Options.MaxLen = 16
+	out := runWire(t, rtPrelude+`
+//wire9 Str n[1] data[n]
+//wire9 Small n[4] data[n,,,max=8]
+//wire9 Strs n[4] strs[n,[]Str,,max=2]
+//wire9 Dflt n[4] data[n]
+//wire9 Huge n[4] data[n,,,max=1<<31-1]
+//wire9 Fixed data[32]
+//wire9 Tail kind[1] payload[*,,,max=4]
+//wire9 Rest payload[*]
+
+func check(name string, in codec, b []byte) {
+	var le *wire.LengthError
+	_, err := in.DecodeFrom(b)
+	rerr := in.ReadBinary(bytes.NewReader(b))
+	fmt.Println(name, err, errors.As(err, &le) && errors.As(rerr, &le) && le.Len == 1<<32-1)
+}
+
+func main() {
+	max := []byte{0xff, 0xff, 0xff, 0xff}
+	check("small", new(Small), max)
+	check("strs", new(Strs), max)
+	check("dflt", new(Dflt), max)
+	rt(&Small{data: []byte("12345678")}, new(Small))
+	rt(&Strs{strs: []Str{{data: []byte("a")}, {data: []byte("b")}}}, new(Strs))
+	rt(&Fixed{data: make([]byte, 32)}, new(Fixed))
+
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	alloc := ms.TotalAlloc
+	var h Huge
+	err := h.ReadBinary(bytes.NewReader([]byte{0xff, 0xff, 0xff, 0x7f, 'a'}))
+	runtime.ReadMemStats(&ms)
+	fmt.Println(err, ms.TotalAlloc-alloc < 1<<20)
+
+	rt(&Tail{1, []byte("abcd")}, new(Tail))
+	_, err = new(Tail).DecodeFrom([]byte("\x01abcdefg"))
+	fmt.Println(err)
+	fmt.Println(new(Tail).ReadBinary(strings.NewReader("\x01abcdefg")))
+	_, err = new(Rest).DecodeFrom(make([]byte, 17))
+	fmt.Println(err)
+}
+`)
+	ckOutput(t, out, `
+small Small.data at offset 4: length 4294967295 exceeds maximum 8 true
+strs Strs.strs at offset 4: length 4294967295 exceeds maximum 2 true
+dflt Dflt.data at offset 4: length 4294967295 exceeds maximum 16 true
+080000003132333435363738 &{8 [49 50 51 52 53 54 55 56]}
+0200000001610162 &{2 [{1 [97]} {1 [98]}]}
+0000000000000000000000000000000000000000000000000000000000000000 &{[0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0]}
+Huge.data at offset 4: short read: 1/2147483647 bytes: unexpected EOF true
+0161626364 &{1 [97 98 99 100]}
+Tail.payload at offset 1: length 7 exceeds maximum 4
+Tail.payload at offset 1: length 5 exceeds maximum 4
+Rest.payload at offset 0: length 17 exceeds maximum 16
+`)
+}
+
+func TestRoundTripConst(t *testing.T) {
+	out := runWire(t, rtPrelude+`
+//wire9 Elf magic[4,"\x7fELF"] class[1]
+//wire9 Ver<BE> ver[1,0x02] tag[2,0xcafe] n[1] name[8,"wire"] data[n]
+//wire9 Opt flags[1] mark[2,0x1234,LE,if flags&1] x[1]
+
+func main() {
+	rt(&Elf{2}, new(Elf))
+	rt(&Ver{data: []byte("ab")}, new(Ver))
+	rt(&Opt{1, 9}, new(Opt))
+	rt(&Opt{0, 9}, new(Opt))
+	fmt.Println(ElfSize)
+
+	for _, b := range [][]byte{
+		[]byte("\x7fELG\x01"),
+		[]byte("\x7fE"),
+	} {
+		var e Elf
+		_, err := e.DecodeFrom(b)
+		rerr := e.ReadBinary(bytes.NewReader(b))
+		fmt.Println(err, err.Error() == rerr.Error())
+	}
+	var v Ver
+	fmt.Println(v.UnmarshalBinary([]byte("\x02\xca\xfd")))
+}
+`)
+	ckOutput(t, out, `
+7f454c4602 &{2}
+02cafe0277697265000000006162 &{2 [97 98]}
+01341209 &{1 9}
+0009 &{0 9}
+5
+Elf.magic at offset 0: have "\x7fELG", want "\x7fELF" true
+Elf.magic at offset 0: short read: 2/4 bytes: unexpected EOF true
+Ver.tag at offset 1: have 0xcafd, want 0xcafe
+`)
+}
+
+func TestRoundTripPadding(t *testing.T) {
+	out := runWire(t, rtPrelude+`
+//wire9 Str n[1] data[n] align(4)
+//wire9 Hdr kind[1] _[3] len[4]
+//wire9 Xdr n[4,,BE] data[n] align(4) tag[2] _[2b] flags[6b] align(8) end[1]
+//wire9 Var n[1] _[n] x[1]
+//wire9 Nested a[1] s[,Str] b[1]
+
+func main() {
+	rt(&Str{data: []byte("abcde")}, new(Str))
+	rt(&Hdr{1, 2}, new(Hdr))
+	rt(&Xdr{data: []byte("x"), tag: 7, flags: 0x3f, end: 9}, new(Xdr))
+	rt(&Var{2, 3}, new(Var))
+	rt(&Nested{1, Str{data: []byte("y")}, 2}, new(Nested))
+	fmt.Println(HdrSize, Str{data: []byte("ab")}.BinarySize(), Xdr{}.BinarySize())
+
+	var h Hdr
+	fmt.Println(h.UnmarshalBinary([]byte{1, 0xff, 0xff, 0xff, 2, 0, 0, 0}), h)
+	var s Str
+	fmt.Println(s.UnmarshalBinary([]byte{1, 'a'}))
+	_, err := h.DecodeFrom([]byte{1, 0})
+	fmt.Println(err)
+}
+`)
+	ckOutput(t, out, `
+0561626364650000 &{5 [97 98 99 100 101]}
+0100000002000000 &{1 2}
+000000017800000007003f000000000009 &{1 [120] 7 63 9}
+02000003 &{2 3}
+010179000002 &{1 {1 [121]} 2}
+8 4 9
+<nil> {1 2}
+Str.align(4) at offset 2: short read: 0/2 bytes: unexpected EOF
+Hdr._ at offset 1: short read: 1/3 bytes: unexpected EOF
+`)
+}
+
+func TestRoundTripNarrow(t *testing.T) {
+	out := runWire(t, rtPrelude+`
+//wire9 U24 le[3] be[3,,BE]
+//wire9 S24 le[3,int32] be[3,int32,BE]
+//wire9 Wide a[5] b[6,int64,BE] c[7] d[2,uint32]
+//wire9 Tls kind[1] ver[2,,BE] n[3,,BE] data[n]
+
+func main() {
+	rt(&U24{0x010203, 0x010203}, new(U24))
+	rt(&S24{-2, -0x800000}, new(S24))
+	rt(&S24{0x7fffff, 1}, new(S24))
+	rt(&Wide{0x0102030405, -3, 1<<56 - 1, 0xffff}, new(Wide))
+	rt(&Tls{22, 0x0303, 0, []byte("hi")}, new(Tls))
+	fmt.Println(U24Size, WideSize)
+
+	rt(&U24{1 << 24, 0}, new(U24))
+	rt(&S24{0x800000, 0}, new(S24))
+	rt(&S24{0, -0x800001}, new(S24))
+	var u U24
+	fmt.Println(u.UnmarshalBinary([]byte{1, 2, 3, 4, 5}))
+}
+`)
+	ckOutput(t, out, `
+030201010203 &{66051 66051}
+feffff800000 &{-2 -8388608}
+ffff7f000001 &{8388607 1}
+0504030201fffffffffffdffffffffffffffffff &{4328719365 -3 72057594037927935 65535}
+1603030000026869 &{22 771 2 [104 105]}
+6 20
+write: U24.le: 16777216 does not fit in 3 bytes
+write: S24.le: 8388608 does not fit in 3 bytes
+write: S24.be: -8388609 does not fit in 3 bytes
+U24.be at offset 3: short read: 2/3 bytes: unexpected EOF
+`)
+}
+
+func TestRoundTripNumSlice(t *testing.T) {
+	out := runWire(t, rtPrelude+`
+//wire9 Vals n[1] vals[n,[]uint32,BE] le[n,[]int16]
+//wire9 Fixed f[2,[]float64,BE] c[,[1]complex64] b[3,[]bool] s[2,[]int8] u[2,[]uint8]
+//wire9 Big n[4] v[n,[]uint64]
+
+func main() {
+	rt(&Vals{vals: []uint32{1, 0xdeadbeef}, le: []int16{-1, 2}}, new(Vals))
+	rt(&Fixed{[]float64{1, -2}, [1]complex64{1 + 2i}, []bool{true, false, true}, []int8{-1, 1}, []uint8{7, 8}}, new(Fixed))
+	fmt.Println(FixedSize, Vals{vals: []uint32{1, 2}, le: []int16{1, 2}}.BinarySize())
+
+	rt(&Vals{vals: []uint32{1}, le: []int16{1, 2}}, new(Vals))
+	rt(&Fixed{}, new(Fixed))
+	var v Vals
+	fmt.Println(v.UnmarshalBinary([]byte{1, 0, 0, 0, 1, 2}))
+	var b Big
+	_, err := b.DecodeFrom([]byte{0xff, 0xff, 0xff, 0xff, 1, 2, 3, 4, 5, 6, 7, 8})
+	fmt.Println(err)
+	fmt.Println(b.UnmarshalBinary([]byte{0xff, 0xff, 0xff, 0xff, 1, 2, 3, 4, 5, 6, 7, 8}))
+}
+`)
+	ckOutput(t, out, `
+0200000001deadbeefffff0200 &{2 [1 3735928559] [-1 2]}
+3ff0000000000000c0000000000000000000803f00000040010001ff010708 &{[1 -2] [(1+2i)] [true false true] [-1 1] [7 8]}
+31 13
+write: Vals.le: length 2 differs from 1 of the other slices counted by n
+write: Fixed.f: have 0 elements, want 2
+Vals.le at offset 5: short read: 1/2 bytes: unexpected EOF
+Big.v at offset 4: short read: 8/34359738360 bytes: unexpected EOF
+Big.v at offset 4: short read: 8/34359738360 bytes: unexpected EOF
+`)
+}
+
+func TestRoundTripArray(t *testing.T) {
+	defer func(a bool) { Options.Arrays = a }(Options.Arrays)
+	Options.Arrays = true
+	out := runWire(t, rtPrelude+`
+//wire9 Point x[2] y[2]
+//wire9 Key id[16,[16]byte] v[2,[2]uint16,BE] pt[2,[2]Point]
+//wire9 Hash sum[20] n[1] tag[n]
+
+func main() {
+	k := Key{
+		id: [16]byte{0: 1, 15: 2},
+		v:  [2]uint16{1, 0x203},
+		pt: [2]Point{{1, 2}, {3, 4}},
+	}
+	rt(&k, new(Key))
+	b, _ := k.MarshalBinary()
+	var k2 Key
+	fmt.Println(k2.UnmarshalBinary(b), k2 == k, map[Key]int{k: 1}[k2], KeySize)
+	fmt.Println(new(Key).UnmarshalBinary(b[:18]))
+	_, err := new(Key).DecodeFrom(b[:22])
+	fmt.Println(err)
+
+	h := Hash{n: 1, tag: []byte("x")}
+	fmt.Printf("%T %d\n", h.sum, h.BinarySize())
+	rt(&h, new(Hash))
+}
+`)
+	ckOutput(t, out, `
+01000000000000000000000000000002000102030100020003000400 &{[1 0 0 0 0 0 0 0 0 0 0 0 0 0 0 2] [1 515] [{1 2} {3 4}]}
+<nil> true 1 28
+Key.v at offset 16: short read: 2/4 bytes: unexpected EOF
+Point.y at offset 22: short read: 0/2 bytes: unexpected EOF
+[20]uint8 22
+00000000000000000000000000000000000000000178 &{[0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0] 1 [120]}
+`)
+}
+
+func TestRoundTripVarint(t *testing.T) {
+	out := runWire(t, rtPrelude+`
+//wire9 Rec n[uvarint] data[n] d[svarint] m[uvarint] vals[m,[]int16] flag[1] opt[uvarint,,,if flag]
+//wire9 Alt q[,varint.QUIC] g[,varint.GitOffset] s[,varint.SQLite] data[q]
+//wire9 Cnt n[,varint.V32] data[n] q[,varint.QUIC] vals[q,[]uint16]
+//wire9 Short n[,varint.V16] data[n]
+
+func main() {
+	rt(&Rec{data: []byte("hi"), d: -3, vals: []int16{1, -1}, flag: 1, opt: 300}, new(Rec))
+	r := Rec{data: make([]byte, 200)}
+	b, err := r.MarshalBinary()
+	fmt.Println(len(b), r.BinarySize(), r.n, err)
+	fmt.Println(new(Rec).UnmarshalBinary([]byte{0x80}))
+	_, err = new(Rec).DecodeFrom([]byte{2, 'h', 'i', 0x80})
+	fmt.Println(err)
+	rt(&Alt{q: 2, g: 128, s: -1, data: []byte("hi")}, new(Alt))
+	fmt.Println(new(Alt).UnmarshalBinary([]byte{0x40}))
+	rt(&Cnt{data: []byte("hi"), vals: []uint16{1}}, new(Cnt))
+	_, err = Short{data: make([]byte, 1<<16)}.MarshalBinary()
+	fmt.Println(err)
+}
+`)
+	ckOutput(t, out, `
+02686905020100ffff01ac02 &{2 [104 105] -3 2 [1 -1] 1 300}
+205 205 0 <nil>
+Rec.n at offset 0: unexpected EOF
+Rec.d at offset 3: unexpected EOF
+028000ffffffffffffffffff6869 &{2 128 -1 [104 105]}
+Alt.q at offset 0: unexpected EOF
+026869010100 &{2 [104 105] 1 [1]}
+Short.n: length 65536 is too large
+`)
+}
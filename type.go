@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"go/ast"
 	"go/types"
+	"math"
 	"strconv"
 )
 
@@ -14,22 +15,48 @@ import (
 type Mode uint
 
 // SizeType maps sizes (as string values) to
-// common datatypes of that size
+// common datatypes of that size. Odd sizes map to
+// the smallest type holding them.
 var sizeType = map[int]ast.Expr{
 	0: &ast.StructType{},
 	1: &ast.Ident{Name: "byte"},
 	2: &ast.Ident{Name: "uint16"},
+	3: &ast.Ident{Name: "uint32"},
 	4: &ast.Ident{Name: "uint32"},
+	5: &ast.Ident{Name: "uint64"},
+	6: &ast.Ident{Name: "uint64"},
+	7: &ast.Ident{Name: "uint64"},
 	8: &ast.Ident{Name: "uint64"},
 }
 
+// varintTypes maps the varint width keywords, as in n[uvarint], to
+// their types in package varint.
+var varintTypes = map[string]ast.Expr{
+	"uvarint": &ast.SelectorExpr{X: &ast.Ident{Name: "varint"}, Sel: &ast.Ident{Name: "V"}},
+	"svarint": &ast.SelectorExpr{X: &ast.Ident{Name: "varint"}, Sel: &ast.Ident{Name: "S"}},
+}
+
+// varintMax maps the integer types in package varint to the largest
+// value they hold.
+var varintMax = map[string]uint64{
+	"varint.V":         math.MaxUint64,
+	"varint.S":         math.MaxInt64,
+	"varint.V16":       math.MaxUint16,
+	"varint.V32":       math.MaxUint32,
+	"varint.Strict":    math.MaxUint64,
+	"varint.QUIC":      1<<62 - 1,
+	"varint.GitOffset": math.MaxUint64,
+	"varint.SQLite":    math.MaxInt64,
+}
+
 var info = types.Info{
 	Types: make(map[ast.Expr]types.TypeAndValue),
 	Defs:  make(map[*ast.Ident]types.Object),
 	Uses:  make(map[*ast.Ident]types.Object),
 }
 
-// TypeFromWidth determines the type by the width
+// TypeFromWidth determines the type by the width. With Options.Arrays,
+// a literal width over 8 bytes is a byte array.
 func TypeFromWidth(w ast.Expr) (ast.Expr, error) {
 	switch t := w.(type) {
 	case *ast.Ident:
@@ -43,6 +70,9 @@ func TypeFromWidth(w ast.Expr) (ast.Expr, error) {
 		if ok {
 			return typ, nil
 		}
+		if Options.Arrays && num > 8 {
+			return &ast.ArrayType{Len: t, Elt: &ast.Ident{Name: "byte"}}, nil
+		}
 		return byteSlice(), nil
 	case *ast.BinaryExpr:
 		return byteSlice(), nil
@@ -54,6 +84,14 @@ func byteSlice() ast.Expr {
 	return SliceOf(&ast.Ident{Name: "byte"})
 }
 
+// unsized holds the builtin integer types whose width depends on the
+// platform. No field or element may have one.
+var unsized = map[string]bool{
+	"int":     true,
+	"uint":    true,
+	"uintptr": true,
+}
+
 var builtin = map[string]bool{
 	"byte":       true,
 	"bool":       true,
@@ -74,3 +112,42 @@ var builtin = map[string]bool{
 	"complex128": true,
 	"string":     true,
 }
+
+// numCodec describes how a builtin numeric type is read from and appended
+// to a byte slice. In get and put, $order is the byte order, $at is the
+// index of the value in b, $v is the value, and $type is the type name.
+type numCodec struct {
+	size     int
+	get, put string
+}
+
+var numCodecs = map[string]numCodec{
+	"byte":       {1, "b[$at]", "b = append(b, $v)"},
+	"uint8":      {1, "b[$at]", "b = append(b, $v)"},
+	"int8":       {1, "int8(b[$at])", "b = append(b, byte($v))"},
+	"bool":       {1, "b[$at] != 0", "if $v { b = append(b, 1) } else { b = append(b, 0) }"},
+	"uint16":     {2, "$order.Uint16(b[$at:])", "b = $order.AppendUint16(b, $v)"},
+	"int16":      {2, "$type($order.Uint16(b[$at:]))", "b = $order.AppendUint16(b, uint16($v))"},
+	"uint32":     {4, "$order.Uint32(b[$at:])", "b = $order.AppendUint32(b, $v)"},
+	"int32":      {4, "$type($order.Uint32(b[$at:]))", "b = $order.AppendUint32(b, uint32($v))"},
+	"rune":       {4, "$type($order.Uint32(b[$at:]))", "b = $order.AppendUint32(b, uint32($v))"},
+	"float32":    {4, "math.Float32frombits($order.Uint32(b[$at:]))", "b = $order.AppendUint32(b, math.Float32bits($v))"},
+	"uint64":     {8, "$order.Uint64(b[$at:])", "b = $order.AppendUint64(b, $v)"},
+	"int64":      {8, "$type($order.Uint64(b[$at:]))", "b = $order.AppendUint64(b, uint64($v))"},
+	"float64":    {8, "math.Float64frombits($order.Uint64(b[$at:]))", "b = $order.AppendUint64(b, math.Float64bits($v))"},
+	"complex64":  {8, "complex(math.Float32frombits($order.Uint32(b[$at:])), math.Float32frombits($order.Uint32(b[$at+4:])))", "b = $order.AppendUint32($order.AppendUint32(b, math.Float32bits(real($v))), math.Float32bits(imag($v)))"},
+	"complex128": {16, "complex(math.Float64frombits($order.Uint64(b[$at:])), math.Float64frombits($order.Uint64(b[$at+8:])))", "b = $order.AppendUint64($order.AppendUint64(b, math.Float64bits(real($v))), math.Float64bits(imag($v)))"},
+}
+
+// intTypes maps the integer types to whether they are signed
+var intTypes = map[string]bool{
+	"byte":   false,
+	"uint8":  false,
+	"uint16": false,
+	"uint32": false,
+	"uint64": false,
+	"int8":   true,
+	"int16":  true,
+	"int32":  true,
+	"int64":  true,
+}
@@ -0,0 +1,185 @@
+package wire9
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/types"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Union describes a field whose type is selected by the value of a
+// preceding tag field:
+//
+//	body[kind, switch{1:Tversion, 2:Rversion, default:Raw}]
+type Union struct {
+	Tag     *ast.Ident
+	Cases   []UnionCase
+	Default ast.Expr // nil if there is no default case
+}
+
+// UnionCase associates a tag value with a type
+type UnionCase struct {
+	Value ast.Expr
+	Type  ast.Expr
+}
+
+// unionType is the data for the tUnion template
+type unionType struct {
+	Name       string
+	Struct     string
+	Field      string
+	SliceCodec bool
+}
+
+// UnionName returns the name of the interface type generated for the
+// union field of the named struct.
+func UnionName(nstruct, field string) string {
+	r, n := utf8.DecodeRuneInString(field)
+	return nstruct + string(unicode.ToUpper(r)) + field[n:]
+}
+
+// check returns an error if a tag value is repeated in u, or if u
+// has no cases
+func (u *Union) check() error {
+	vals := make(map[string]bool)
+	for _, c := range u.Cases {
+		v := types.ExprString(c.Value)
+		if vals[v] {
+			return fmt.Errorf("duplicate case %s", v)
+		}
+		vals[v] = true
+	}
+	if len(u.Cases) == 0 && u.Default == nil {
+		return fmt.Errorf("switch has no cases")
+	}
+	return nil
+}
+
+// UnionOf returns the union description of f, or nil if f is not a
+// union field.
+func UnionOf(ts *ast.TypeSpec, f *ast.Field) *Union {
+	info := TInfo.Get(ts, f)
+	if info == nil {
+		return nil
+	}
+	return info.Union
+}
+
+// IsUnion returns true if f is a union field
+func IsUnion(ts *ast.TypeSpec, f *ast.Field) bool {
+	return UnionOf(ts, f) != nil
+}
+
+// unionTypes returns the distinct types of u in order of appearance,
+// with the tag values selecting each of them.
+func unionTypes(u *Union) (typs []string, vals map[string][]string) {
+	vals = make(map[string][]string)
+	add := func(t string, v string) {
+		if _, ok := vals[t]; !ok {
+			typs = append(typs, t)
+			vals[t] = nil
+		}
+		if v != "" {
+			vals[t] = append(vals[t], v)
+		}
+	}
+	for _, c := range u.Cases {
+		add(TypeString(c.Type), types.ExprString(c.Value))
+	}
+	if u.Default != nil {
+		add(TypeString(u.Default), "")
+	}
+	return typs, vals
+}
+
+// selectUnion writes a switch on the tag that sets the union field to
+// a value of the selected type. The existing value is kept if it
+// already has that type. ret is prepended to the error result.
+func selectUnion(b *bytes.Buffer, ts *ast.TypeSpec, f *ast.Field, ret string) {
+	u := UnionOf(ts, f)
+	name, tag := "z."+f.Names[0].Name, "z."+u.Tag.Name
+	set := func(typ string) {
+		fmt.Fprintf(b, "if _, ok := %s.(*%s); !ok {\n%s = new(%s)\n}\n", name, typ, name, typ)
+	}
+	fmt.Fprintf(b, "switch %s {\n", tag)
+	for _, c := range u.Cases {
+		fmt.Fprintf(b, "case %s:\n", types.ExprString(c.Value))
+		set(TypeString(c.Type))
+	}
+	fmt.Fprintf(b, "default:\n")
+	if u.Default != nil {
+		set(TypeString(u.Default))
+	} else {
+		fmt.Fprintf(b, "return %sfmt.Errorf(\"unknown %s: %%v\", %s)\n", ret, u.Tag.Name, tag)
+	}
+	fmt.Fprintf(b, "}\n")
+}
+
+// ReadUnion returns a block reading the union field f from r
+func ReadUnion(ts *ast.TypeSpec, f *ast.Field) string {
+	b := new(bytes.Buffer)
+	fmt.Fprintf(b, "{\n")
+	selectUnion(b, ts, f, "")
+	fmt.Fprintf(b, "if err := z.%s.ReadBinary(r); err != nil {\nreturn err\n}\n}\n", f.Names[0].Name)
+	return b.String()
+}
+
+// DecodeUnion returns a block decoding the union field f from b[n:]
+func DecodeUnion(ts *ast.TypeSpec, f *ast.Field) string {
+	b := new(bytes.Buffer)
+	fmt.Fprintf(b, "{\n")
+	selectUnion(b, ts, f, "n, ")
+	fmt.Fprintf(b, "m, err := z.%s.DecodeFrom(b[n:])\nn += m\nif err != nil {\nreturn n, err\n}\n}\n", f.Names[0].Name)
+	return b.String()
+}
+
+// SetTags returns statements setting or verifying the tag of every union
+// field in ts from the dynamic type of the field's value. ret is
+// prepended to the error result.
+func SetTags(ts *ast.TypeSpec, ret string) string {
+	b := new(bytes.Buffer)
+	for _, f := range ts.Type.(*ast.StructType).Fields.List {
+		u := UnionOf(ts, f)
+		if u == nil {
+			continue
+		}
+		name, tag := "z."+f.Names[0].Name, "z."+u.Tag.Name
+		where := ts.Name.Name + "." + f.Names[0].Name
+		typs, vals := unionTypes(u)
+		fmt.Fprintf(b, "switch %s.(type) {\n", name)
+		for _, t := range typs {
+			fmt.Fprintf(b, "case *%s:\n", t)
+			v := vals[t]
+			switch {
+			case u.Default != nil && TypeString(u.Default) == t:
+				// The default type may carry any tag not
+				// selecting another type
+				var other []string
+				for _, c := range u.Cases {
+					if TypeString(c.Type) != t {
+						other = append(other, fmt.Sprintf("%s == %s", tag, types.ExprString(c.Value)))
+					}
+				}
+				if len(other) > 0 {
+					fmt.Fprintf(b, "if %s {\n", strings.Join(other, " || "))
+					fmt.Fprintf(b, "return %sfmt.Errorf(\"%s: %%T with %s %%v\", %s, %s)\n}\n", ret, where, u.Tag.Name, name, tag)
+				}
+			case len(v) == 1:
+				fmt.Fprintf(b, "%s = %s\n", tag, v[0])
+			default:
+				var in []string
+				for _, x := range v {
+					in = append(in, fmt.Sprintf("%s != %s", tag, x))
+				}
+				fmt.Fprintf(b, "if %s {\n", strings.Join(in, " && "))
+				fmt.Fprintf(b, "return %sfmt.Errorf(\"%s: %%T with %s %%v\", %s, %s)\n}\n", ret, where, u.Tag.Name, name, tag)
+			}
+		}
+		fmt.Fprintf(b, "case nil:\nreturn %sfmt.Errorf(\"%s: nil\")\n", ret, where)
+		fmt.Fprintf(b, "default:\nreturn %sfmt.Errorf(\"%s: unexpected type %%T\", %s)\n}\n", ret, where, name)
+	}
+	return b.String()
+}
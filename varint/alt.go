@@ -0,0 +1,243 @@
+package varint
+
+import (
+	"io"
+	"math/bits"
+)
+
+// MaxQUIC is the largest value a QUIC varint holds
+const MaxQUIC = 1<<62 - 1
+
+// QUIC is a varint in the encoding of RFC 9000. The two high bits of
+// the first byte hold the base 2 logarithm of its length, 1, 2, 4 or 8
+// bytes, and the rest of the bytes hold the value in big-endian order.
+// WriteBinary returns ErrOverflow if the value exceeds MaxQUIC.
+type QUIC uint64
+
+// WriteBinary writes the varint to the underlying writer.
+func (v QUIC) WriteBinary(w io.Writer) error {
+	return writeAppender(w, v)
+}
+
+// ReadBinary reads a varint from the underlying reader. It does not
+// read beyond the varint.
+func (v *QUIC) ReadBinary(r io.Reader) error {
+	var buf [8]byte
+	b, err := readUntil(r, buf[:], func(b []byte) bool { return len(b) == 1<<(b[0]>>6) })
+	if err != nil {
+		return err
+	}
+	_, err = v.DecodeFrom(b)
+	return err
+}
+
+// BinarySize returns the length of v's binary encoding
+func (v QUIC) BinarySize() int {
+	switch {
+	case v < 1<<6:
+		return 1
+	case v < 1<<14:
+		return 2
+	case v < 1<<30:
+		return 4
+	}
+	return 8
+}
+
+// AppendBinary appends the binary encoding of v to b
+func (v QUIC) AppendBinary(b []byte) ([]byte, error) {
+	if v > MaxQUIC {
+		return b, ErrOverflow
+	}
+	n := v.BinarySize()
+	for i := n - 1; i >= 0; i-- {
+		b = append(b, byte(v>>(8*uint(i))))
+	}
+	b[len(b)-n] |= byte(bits.TrailingZeros(uint(n))) << 6
+	return b, nil
+}
+
+// DecodeFrom decodes v from b and returns the number of bytes read.
+func (v *QUIC) DecodeFrom(b []byte) (int, error) {
+	if len(b) == 0 || len(b) < 1<<(b[0]>>6) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	n := 1 << (b[0] >> 6)
+	x := QUIC(b[0] & 0x3f)
+	for _, c := range b[1:n] {
+		x = x<<8 | QUIC(c)
+	}
+	*v = x
+	return n, nil
+}
+
+// GitOffset is a varint in the encoding of the offsets of OFS_DELTA
+// objects in Git pack files. Groups of 7 bits are in big-endian order,
+// and each continuation adds one to the value, so every value has
+// exactly one encoding.
+type GitOffset uint64
+
+// WriteBinary writes the varint to the underlying writer.
+func (v GitOffset) WriteBinary(w io.Writer) error {
+	return writeAppender(w, v)
+}
+
+// ReadBinary reads a varint from the underlying reader. It does not
+// read beyond the varint.
+func (v *GitOffset) ReadBinary(r io.Reader) error {
+	var buf [10]byte
+	b, err := readUntil(r, buf[:], func(b []byte) bool { return b[len(b)-1] < 128 })
+	if err != nil {
+		return err
+	}
+	_, err = v.DecodeFrom(b)
+	return err
+}
+
+// BinarySize returns the length of v's binary encoding
+func (v GitOffset) BinarySize() int {
+	var buf [10]byte
+	b, _ := v.AppendBinary(buf[:0])
+	return len(b)
+}
+
+// AppendBinary appends the binary encoding of v to b
+func (v GitOffset) AppendBinary(b []byte) ([]byte, error) {
+	var buf [10]byte
+	i := len(buf) - 1
+	buf[i] = byte(v & 127)
+	for v >>= 7; v != 0; v >>= 7 {
+		v--
+		i--
+		buf[i] = 128 | byte(v&127)
+	}
+	return append(b, buf[i:]...), nil
+}
+
+// DecodeFrom decodes v from b and returns the number of bytes read. It
+// returns ErrOverflow if the value does not fit in 64 bits.
+func (v *GitOffset) DecodeFrom(b []byte) (int, error) {
+	var x GitOffset
+	for i, c := range b {
+		if i > 0 {
+			if x >= 1<<57-1 {
+				return 0, ErrOverflow
+			}
+			x++
+		}
+		x = x<<7 | GitOffset(c&127)
+		if c < 128 {
+			*v = x
+			return i + 1, nil
+		}
+		if i == MaxVLen64-1 {
+			return 0, ErrOverflow
+		}
+	}
+	return 0, io.ErrUnexpectedEOF
+}
+
+// SQLite is a varint in the encoding of the SQLite file format. It is
+// one to nine bytes long. The first eight bytes hold groups of 7 bits
+// in big-endian order, and the ninth, if present, holds the low 8 bits.
+type SQLite int64
+
+// WriteBinary writes the varint to the underlying writer.
+func (v SQLite) WriteBinary(w io.Writer) error {
+	return writeAppender(w, v)
+}
+
+// ReadBinary reads a varint from the underlying reader. It does not
+// read beyond the varint.
+func (v *SQLite) ReadBinary(r io.Reader) error {
+	var buf [9]byte
+	b, err := readUntil(r, buf[:], func(b []byte) bool { return b[len(b)-1] < 128 })
+	if err != nil {
+		return err
+	}
+	_, err = v.DecodeFrom(b)
+	return err
+}
+
+// BinarySize returns the length of v's binary encoding
+func (v SQLite) BinarySize() int {
+	n := 1
+	for u := uint64(v) >> 7; u != 0 && n < 9; u >>= 7 {
+		n++
+	}
+	return n
+}
+
+// AppendBinary appends the binary encoding of v to b
+func (v SQLite) AppendBinary(b []byte) ([]byte, error) {
+	var buf [9]byte
+	u := uint64(v)
+	if u>>56 != 0 {
+		buf[8] = byte(u)
+		u >>= 8
+		for i := 7; i >= 0; i-- {
+			buf[i] = 128 | byte(u&127)
+			u >>= 7
+		}
+		return append(b, buf[:]...), nil
+	}
+	i := len(buf) - 1
+	buf[i] = byte(u & 127)
+	for u >>= 7; u != 0; u >>= 7 {
+		i--
+		buf[i] = 128 | byte(u&127)
+	}
+	return append(b, buf[i:]...), nil
+}
+
+// DecodeFrom decodes v from b and returns the number of bytes read.
+func (v *SQLite) DecodeFrom(b []byte) (int, error) {
+	var x uint64
+	for i := 0; i < 9; i++ {
+		if i == len(b) {
+			return 0, io.ErrUnexpectedEOF
+		}
+		if i == 8 {
+			x = x<<8 | uint64(b[i])
+			break
+		}
+		x = x<<7 | uint64(b[i]&127)
+		if b[i] < 128 {
+			*v = SQLite(x)
+			return i + 1, nil
+		}
+	}
+	*v = SQLite(x)
+	return 9, nil
+}
+
+// writeAppender writes the encoding of v to w in one call
+func writeAppender(w io.Writer, v interface {
+	AppendBinary([]byte) ([]byte, error)
+}) error {
+	var buf [10]byte
+	b, err := v.AppendBinary(buf[:0])
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// readUntil reads bytes from r into buf until done reports that the
+// bytes read so far end the varint, or buf is full. It returns io.EOF
+// if no bytes were read, and io.ErrUnexpectedEOF if r ends early.
+func readUntil(r io.Reader, buf []byte, done func(b []byte) bool) ([]byte, error) {
+	for i := range buf {
+		if _, err := io.ReadFull(r, buf[i:i+1]); err != nil {
+			if err == io.EOF && i != 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if done(buf[:i+1]) {
+			return buf[:i+1], nil
+		}
+	}
+	return buf, nil
+}
@@ -0,0 +1,107 @@
+package varint
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"testing"
+)
+
+type codec interface {
+	WriteBinary(io.Writer) error
+	BinarySize() int
+	AppendBinary([]byte) ([]byte, error)
+}
+
+type decoder interface {
+	ReadBinary(io.Reader) error
+	DecodeFrom([]byte) (int, error)
+}
+
+// testCodec checks that in encodes as want, and that want decodes as
+// in through out
+func testCodec(t *testing.T, in codec, out decoder, want string) {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	err := in.WriteBinary(buf)
+	b, _ := in.AppendBinary(nil)
+	if err != nil || buf.String() != want || string(b) != want || in.BinarySize() != len(want) {
+		t.Errorf("%T %v: have % x % x %d %v, want % x", in, in, buf.Bytes(), b, in.BinarySize(), err, want)
+	}
+	r := bytes.NewReader([]byte(want + "tail"))
+	if err := out.ReadBinary(r); err != nil || fmt.Sprint(elem(out)) != fmt.Sprint(in) || r.Len() != 4 {
+		t.Errorf("%T % x: read %v %v", in, want, elem(out), err)
+	}
+	if n, err := out.DecodeFrom([]byte(want + "tail")); err != nil || n != len(want) || fmt.Sprint(elem(out)) != fmt.Sprint(in) {
+		t.Errorf("%T % x: decode %v %d %v", in, want, elem(out), n, err)
+	}
+	if _, err := out.DecodeFrom([]byte(want[:len(want)-1])); err != io.ErrUnexpectedEOF {
+		t.Errorf("%T % x: short decode: %v", in, want, err)
+	}
+}
+
+func elem(d decoder) interface{} {
+	switch d := d.(type) {
+	case *QUIC:
+		return *d
+	case *GitOffset:
+		return *d
+	case *SQLite:
+		return *d
+	}
+	return d
+}
+
+func TestQUIC(t *testing.T) {
+	// RFC 9000, appendix A.1
+	testCodec(t, QUIC(151288809941952652), new(QUIC), "\xc2\x19\x7c\x5e\xff\x14\xe8\x8c")
+	testCodec(t, QUIC(494878333), new(QUIC), "\x9d\x7f\x3e\x7d")
+	testCodec(t, QUIC(15293), new(QUIC), "\x7b\xbd")
+	testCodec(t, QUIC(37), new(QUIC), "\x25")
+	testCodec(t, QUIC(63), new(QUIC), "\x3f")
+	testCodec(t, QUIC(64), new(QUIC), "\x40\x40")
+	testCodec(t, QUIC(MaxQUIC), new(QUIC), "\xff\xff\xff\xff\xff\xff\xff\xff")
+
+	var v QUIC
+	if n, err := v.DecodeFrom([]byte{0x40, 0x25}); n != 2 || err != nil || v != 37 {
+		t.Errorf("two byte 37: %d %d %v", v, n, err)
+	}
+	if err := QUIC(MaxQUIC + 1).WriteBinary(io.Discard); err != ErrOverflow {
+		t.Errorf("MaxQUIC+1: %v", err)
+	}
+}
+
+func TestGitOffset(t *testing.T) {
+	testCodec(t, GitOffset(0), new(GitOffset), "\x00")
+	testCodec(t, GitOffset(127), new(GitOffset), "\x7f")
+	testCodec(t, GitOffset(128), new(GitOffset), "\x80\x00")
+	testCodec(t, GitOffset(16511), new(GitOffset), "\xff\x7f")
+	testCodec(t, GitOffset(16512), new(GitOffset), "\x80\x80\x00")
+	testCodec(t, GitOffset(math.MaxUint64), new(GitOffset), "\x80\xfe\xfe\xfe\xfe\xfe\xfe\xfe\xfe\x7f")
+
+	var v GitOffset
+	if err := v.ReadBinary(bytes.NewReader([]byte("\x80\xfe\xfe\xfe\xfe\xfe\xfe\xfe\xff\x00"))); err != ErrOverflow {
+		t.Errorf("MaxUint64+1: %v", err)
+	}
+	long := bytes.Repeat([]byte{0x80}, 10)
+	if err := v.ReadBinary(bytes.NewReader(long)); err != ErrOverflow {
+		t.Errorf("ReadBinary of 10 continuation bytes: %v", err)
+	}
+	if _, err := v.DecodeFrom(append(long, 0)); err != ErrOverflow {
+		t.Errorf("DecodeFrom of 10 continuation bytes: %v", err)
+	}
+	if _, err := v.DecodeFrom(long[:9]); err != io.ErrUnexpectedEOF {
+		t.Errorf("DecodeFrom of 9 continuation bytes: %v", err)
+	}
+}
+
+func TestSQLite(t *testing.T) {
+	testCodec(t, SQLite(0), new(SQLite), "\x00")
+	testCodec(t, SQLite(127), new(SQLite), "\x7f")
+	testCodec(t, SQLite(128), new(SQLite), "\x81\x00")
+	testCodec(t, SQLite(1<<56-1), new(SQLite), "\xff\xff\xff\xff\xff\xff\xff\x7f")
+	testCodec(t, SQLite(1<<56), new(SQLite), "\x80\xc0\x80\x80\x80\x80\x80\x80\x00")
+	testCodec(t, SQLite(-1), new(SQLite), "\xff\xff\xff\xff\xff\xff\xff\xff\xff")
+	testCodec(t, SQLite(math.MinInt64), new(SQLite), "\xc0\x80\x80\x80\x80\x80\x80\x80\x00")
+}
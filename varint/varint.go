@@ -1,5 +1,8 @@
-// Package varint provides the varint type V. V knows how to write
-// and read its binary encoded representation from a reader or writer
+// Package varint provides the varint types V and S, the bounded types
+// V16 and V32, and Strict, which reads only canonical varints. QUIC,
+// GitOffset and SQLite are the varints of other formats. They know how
+// to write and read their binary encoded representation from a reader
+// or writer, and from a byte slice.
 package varint
 
 import (
@@ -21,10 +24,16 @@ var (
 // V's varint value to the writer.
 //
 // This implementation does not handle signed values or zig-zag
-// encoding.
+// encoding; see S.
 type V uint64
 
-var ErrOverflow = errors.New("varint: varint overflows a 64-bit integer")
+// ErrOverflow is returned when a varint is longer than its type
+// allows, or its value does not fit in the type.
+var ErrOverflow = errors.New("varint: varint overflows its type")
+
+// ErrNonCanonical is returned by Strict when a varint is longer than
+// the shortest encoding of its value.
+var ErrNonCanonical = errors.New("varint: non-canonical encoding")
 
 // WriteBinary writes the varint to the underlying writer.
 func (v V) WriteBinary(w io.Writer) (err error) {
@@ -46,23 +55,268 @@ func (v V) WriteBinary(w io.Writer) (err error) {
 }
 
 // ReadBinary read a varint from the underlying reader. It does not
-// read beyond the varint.
+// read beyond the varint. It returns io.EOF if no bytes were read, and
+// io.ErrUnexpectedEOF if the varint is incomplete.
 func (v *V) ReadBinary(r io.Reader) error {
+	x, err := readUvarint(r, MaxVLen64, 64, false)
+	if err != nil {
+		return err
+	}
+	*v = V(x)
+	return nil
+}
+
+// BinarySize returns the length of v's binary encoding
+func (v V) BinarySize() int {
+	n := 1
+	for ; v >= 128; v >>= 7 {
+		n++
+	}
+	return n
+}
+
+// AppendBinary appends the binary encoding of v to b
+func (v V) AppendBinary(b []byte) ([]byte, error) {
+	for ; v >= 128; v >>= 7 {
+		b = append(b, byte(v)|128)
+	}
+	return append(b, byte(v)), nil
+}
+
+// DecodeFrom decodes v from b and returns the number of bytes read. It
+// returns io.ErrUnexpectedEOF if b ends before the varint.
+func (v *V) DecodeFrom(b []byte) (int, error) {
+	x, n, err := decodeUvarint(b, MaxVLen64, 64, false)
+	if err != nil {
+		return 0, err
+	}
+	*v = V(x)
+	return n, nil
+}
+
+// S is a signed varint. It is zig-zag encoded before it is written as
+// a V, so values near zero have short encodings whatever their sign.
+type S int64
+
+// zigzag returns the unsigned varint encoding s
+func (s S) zigzag() V {
+	return V(uint64(s)<<1 ^ uint64(s>>63))
+}
+
+// unzigzag returns the signed value encoded by v
+func unzigzag(v V) S {
+	return S(int64(v>>1) ^ -int64(v&1))
+}
+
+// WriteBinary writes the varint to the underlying writer.
+func (s S) WriteBinary(w io.Writer) error {
+	return s.zigzag().WriteBinary(w)
+}
+
+// ReadBinary reads a varint from the underlying reader, as V does.
+func (s *S) ReadBinary(r io.Reader) error {
+	var v V
+	if err := v.ReadBinary(r); err != nil {
+		return err
+	}
+	*s = unzigzag(v)
+	return nil
+}
+
+// BinarySize returns the length of s's binary encoding
+func (s S) BinarySize() int {
+	return s.zigzag().BinarySize()
+}
+
+// AppendBinary appends the binary encoding of s to b
+func (s S) AppendBinary(b []byte) ([]byte, error) {
+	return s.zigzag().AppendBinary(b)
+}
+
+// DecodeFrom decodes s from b and returns the number of bytes read.
+func (s *S) DecodeFrom(b []byte) (int, error) {
+	var v V
+	n, err := v.DecodeFrom(b)
+	if err == nil {
+		*s = unzigzag(v)
+	}
+	return n, err
+}
+
+// V16 is a varint holding at most 16 bits. Its encoding is at most
+// MaxVLen16 bytes long.
+type V16 uint16
+
+// WriteBinary writes the varint to the underlying writer.
+func (v V16) WriteBinary(w io.Writer) error {
+	return V(v).WriteBinary(w)
+}
+
+// ReadBinary reads a varint from the underlying reader, as V does. It
+// returns ErrOverflow if the value does not fit in 16 bits.
+func (v *V16) ReadBinary(r io.Reader) error {
+	x, err := readUvarint(r, MaxVLen16, 16, false)
+	if err != nil {
+		return err
+	}
+	*v = V16(x)
+	return nil
+}
+
+// BinarySize returns the length of v's binary encoding
+func (v V16) BinarySize() int {
+	return V(v).BinarySize()
+}
+
+// AppendBinary appends the binary encoding of v to b
+func (v V16) AppendBinary(b []byte) ([]byte, error) {
+	return V(v).AppendBinary(b)
+}
+
+// DecodeFrom decodes v from b and returns the number of bytes read.
+func (v *V16) DecodeFrom(b []byte) (int, error) {
+	x, n, err := decodeUvarint(b, MaxVLen16, 16, false)
+	if err != nil {
+		return 0, err
+	}
+	*v = V16(x)
+	return n, nil
+}
+
+// V32 is a varint holding at most 32 bits. Its encoding is at most
+// MaxVLen32 bytes long.
+type V32 uint32
+
+// WriteBinary writes the varint to the underlying writer.
+func (v V32) WriteBinary(w io.Writer) error {
+	return V(v).WriteBinary(w)
+}
+
+// ReadBinary reads a varint from the underlying reader, as V does. It
+// returns ErrOverflow if the value does not fit in 32 bits.
+func (v *V32) ReadBinary(r io.Reader) error {
+	x, err := readUvarint(r, MaxVLen32, 32, false)
+	if err != nil {
+		return err
+	}
+	*v = V32(x)
+	return nil
+}
+
+// BinarySize returns the length of v's binary encoding
+func (v V32) BinarySize() int {
+	return V(v).BinarySize()
+}
+
+// AppendBinary appends the binary encoding of v to b
+func (v V32) AppendBinary(b []byte) ([]byte, error) {
+	return V(v).AppendBinary(b)
+}
+
+// DecodeFrom decodes v from b and returns the number of bytes read.
+func (v *V32) DecodeFrom(b []byte) (int, error) {
+	x, n, err := decodeUvarint(b, MaxVLen32, 32, false)
+	if err != nil {
+		return 0, err
+	}
+	*v = V32(x)
+	return n, nil
+}
+
+// Strict is a V that only reads canonical varints: those without
+// trailing zero groups, such as 0x80 0x00 for zero. WriteBinary always
+// writes the canonical encoding. Use it for formats where an encoding
+// must be unique, as when it is covered by a signature.
+type Strict uint64
+
+// WriteBinary writes the varint to the underlying writer.
+func (v Strict) WriteBinary(w io.Writer) error {
+	return V(v).WriteBinary(w)
+}
+
+// ReadBinary reads a varint from the underlying reader, as V does. It
+// returns ErrNonCanonical if the varint is not canonical.
+func (v *Strict) ReadBinary(r io.Reader) error {
+	x, err := readUvarint(r, MaxVLen64, 64, true)
+	if err != nil {
+		return err
+	}
+	*v = Strict(x)
+	return nil
+}
+
+// BinarySize returns the length of v's binary encoding
+func (v Strict) BinarySize() int {
+	return V(v).BinarySize()
+}
+
+// AppendBinary appends the binary encoding of v to b
+func (v Strict) AppendBinary(b []byte) ([]byte, error) {
+	return V(v).AppendBinary(b)
+}
+
+// DecodeFrom decodes v from b and returns the number of bytes read.
+func (v *Strict) DecodeFrom(b []byte) (int, error) {
+	x, n, err := decodeUvarint(b, MaxVLen64, 64, true)
+	if err != nil {
+		return 0, err
+	}
+	*v = Strict(x)
+	return n, nil
+}
+
+// overflows returns true if c is not a valid final byte for the i'th
+// byte of a varint of at most max bytes holding bits bits. The final
+// byte holds the bits left over from the bytes before it.
+func overflows(c byte, i, max int, bits uint) bool {
+	return i == max-1 && uint(c) >= 1<<(bits-7*uint(i))
+}
+
+// readUvarint reads a varint of at most max bytes holding bits bits
+// from r. If strict is set, the varint must be canonical.
+func readUvarint(r io.Reader, max int, bits uint, strict bool) (uint64, error) {
 	var b [1]byte
-	m := int64(1)
-	for n := 0; n < MaxVLen64; n++ {
-		_, err := r.Read(b[:])
-		if err != nil && err != io.EOF {
-			return err
+	var x uint64
+	for i := 0; i < max; i++ {
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			if err == io.EOF && i != 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		if overflows(b[0], i, max, bits) {
+			return 0, ErrOverflow
+		}
+		x |= uint64(b[0]&127) << (7 * uint(i))
+		if b[0] < 128 {
+			if strict && b[0] == 0 && i != 0 {
+				return 0, ErrNonCanonical
+			}
+			return x, nil
+		}
+	}
+	return 0, ErrOverflow
+}
+
+// decodeUvarint decodes a varint of at most max bytes holding bits
+// bits from b. It returns the value and the number of bytes read. If
+// strict is set, the varint must be canonical.
+func decodeUvarint(b []byte, max int, bits uint, strict bool) (uint64, int, error) {
+	var x uint64
+	for i := 0; i < max; i++ {
+		if i == len(b) {
+			return 0, 0, io.ErrUnexpectedEOF
 		}
-		*v += V((int64(b[0]&127) * m))
-		m *= 128
-		if b[0]&128 == 0 {
-			return nil
+		if overflows(b[i], i, max, bits) {
+			return 0, 0, ErrOverflow
 		}
-		if err == io.EOF {
-			return io.ErrUnexpectedEOF
+		x |= uint64(b[i]&127) << (7 * uint(i))
+		if b[i] < 128 {
+			if strict && b[i] == 0 && i != 0 {
+				return 0, 0, ErrNonCanonical
+			}
+			return x, i + 1, nil
 		}
 	}
-	return ErrOverflow
+	return 0, 0, ErrOverflow
 }
@@ -1,7 +1,14 @@
 package varint
 
-import "testing"
-import "bytes"
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"reflect"
+	"testing"
+)
 
 func testRead(t *testing.T, name string, want int, in string) {
 	buf := bytes.NewBufferString(in)
@@ -65,3 +72,147 @@ func TestReadBinary(t *testing.T) {
 	testRead(t, "128^4+0", 128*128*128*128, "\x80\x80\x80\x80\x01")
 	testRead(t, "128^8+0", 128*128*128*128*128*128*128*128, "\x80\x80\x80\x80\x80\x80\x80\x80\x01")
 }
+
+func TestSliceCodec(t *testing.T) {
+	for _, x := range []V{0, 1, 127, 128, 128*128 - 1, 128 * 128, 1<<63 + 1, 1<<64 - 1} {
+		buf := new(bytes.Buffer)
+		x.WriteBinary(buf)
+		b, _ := x.AppendBinary(nil)
+		if !bytes.Equal(b, buf.Bytes()) || x.BinarySize() != len(b) {
+			t.Errorf("%d: append %x size %d, want %x", x, b, x.BinarySize(), buf.Bytes())
+		}
+		var v V
+		if n, err := v.DecodeFrom(append(b, 0xff)); n != len(b) || err != nil || v != x {
+			t.Errorf("%d: decode %d %d %v", x, v, n, err)
+		}
+		if _, err := v.DecodeFrom(b[:len(b)-1]); err != io.ErrUnexpectedEOF {
+			t.Errorf("%d: short decode: %v", x, err)
+		}
+	}
+}
+
+func TestReadEOF(t *testing.T) {
+	v := V(5)
+	if err := v.ReadBinary(bytes.NewReader(nil)); err != io.EOF {
+		t.Errorf("empty: %v", err)
+	}
+	if err := v.ReadBinary(bytes.NewReader([]byte{0x80})); err != io.ErrUnexpectedEOF {
+		t.Errorf("short: %v", err)
+	}
+	if err := v.ReadBinary(bytes.NewReader([]byte{0x01})); err != nil || v != 1 {
+		t.Errorf("reuse: have %d %v, want 1", v, err)
+	}
+}
+
+func TestSigned(t *testing.T) {
+	for _, x := range []int64{0, 1, -1, 63, -64, 64, -65, math.MaxInt32, math.MinInt32, math.MaxInt64, math.MinInt64} {
+		want := binary.AppendVarint(nil, x)
+		buf := new(bytes.Buffer)
+		if err := S(x).WriteBinary(buf); err != nil || !bytes.Equal(buf.Bytes(), want) {
+			t.Errorf("%d: write %x %v, want %x", x, buf.Bytes(), err, want)
+		}
+		if b, _ := S(x).AppendBinary(nil); !bytes.Equal(b, want) || S(x).BinarySize() != len(want) {
+			t.Errorf("%d: append %x size %d, want %x", x, b, S(x).BinarySize(), want)
+		}
+		if y, err := binary.ReadVarint(bufio.NewReader(bytes.NewReader(buf.Bytes()))); err != nil || y != x {
+			t.Errorf("%d: binary.ReadVarint %d %v", x, y, err)
+		}
+
+		var s S
+		if err := s.ReadBinary(bytes.NewReader(want)); err != nil || int64(s) != x {
+			t.Errorf("%d: read %d %v", x, s, err)
+		}
+		s = 0
+		if n, err := s.DecodeFrom(want); err != nil || n != len(want) || int64(s) != x {
+			t.Errorf("%d: decode %d %d %v", x, s, n, err)
+		}
+	}
+}
+
+func TestOverflow(t *testing.T) {
+	type wire interface {
+		ReadBinary(io.Reader) error
+		DecodeFrom([]byte) (int, error)
+	}
+	for _, tc := range []struct {
+		v    wire
+		in   string
+		want uint64
+		err  error
+	}{
+		{new(V), "\xff\xff\xff\xff\xff\xff\xff\xff\xff\x01", math.MaxUint64, nil},
+		{new(V), "\xff\xff\xff\xff\xff\xff\xff\xff\xff\x02", 0, ErrOverflow},
+		{new(V), "\x80\x80\x80\x80\x80\x80\x80\x80\x80\x80\x00", 0, ErrOverflow},
+		{new(V32), "\xff\xff\xff\xff\x0f", math.MaxUint32, nil},
+		{new(V32), "\xff\xff\xff\xff\x10", 0, ErrOverflow},
+		{new(V32), "\x80\x80\x80\x80\x80\x00", 0, ErrOverflow},
+		{new(V16), "\xff\xff\x03", math.MaxUint16, nil},
+		{new(V16), "\x80\x80\x04", 0, ErrOverflow},
+		{new(V16), "\x80\x80", 0, io.ErrUnexpectedEOF},
+	} {
+		rerr := tc.v.ReadBinary(bytes.NewReader([]byte(tc.in)))
+		have := reflect.ValueOf(tc.v).Elem().Uint()
+		_, derr := tc.v.DecodeFrom([]byte(tc.in))
+		if rerr != tc.err || derr != tc.err || have != tc.want {
+			t.Errorf("%T %x: have %d %v %v, want %d %v", tc.v, tc.in, have, rerr, derr, tc.want, tc.err)
+		}
+	}
+	for _, x := range []uint64{0, 127, 128, math.MaxUint16} {
+		b, _ := V16(x).AppendBinary(nil)
+		var v V16
+		if n, err := v.DecodeFrom(b); err != nil || n != len(b) || uint64(v) != x || len(b) > MaxVLen16 {
+			t.Errorf("V16 %d: decode %d %d %v", x, v, n, err)
+		}
+	}
+}
+
+func TestStrict(t *testing.T) {
+	for _, in := range []string{"\x80\x00", "\xff\x00", "\x81\x80\x00"} {
+		var v Strict
+		rerr := v.ReadBinary(bytes.NewReader([]byte(in)))
+		_, derr := v.DecodeFrom([]byte(in))
+		if rerr != ErrNonCanonical || derr != ErrNonCanonical {
+			t.Errorf("%x: have %v %v, want %v", in, rerr, derr, ErrNonCanonical)
+		}
+		var u V
+		if err := u.ReadBinary(bytes.NewReader([]byte(in))); err != nil {
+			t.Errorf("%x: V: %v", in, err)
+		}
+	}
+}
+
+func FuzzStrictWrite(f *testing.F) {
+	for _, x := range []uint64{0, 1, 127, 128, 1<<63 - 1, 1<<64 - 1} {
+		f.Add(x)
+	}
+	f.Fuzz(func(t *testing.T, x uint64) {
+		buf := new(bytes.Buffer)
+		if err := V(x).WriteBinary(buf); err != nil {
+			t.Fatal(err)
+		}
+		b := buf.Bytes()
+		var r, d Strict
+		if err := r.ReadBinary(bytes.NewReader(b)); err != nil || uint64(r) != x {
+			t.Fatalf("%d: read %x: %d %v", x, b, r, err)
+		}
+		if n, err := d.DecodeFrom(b); err != nil || n != len(b) || uint64(d) != x {
+			t.Fatalf("%d: decode %x: %d %d %v", x, b, d, n, err)
+		}
+	})
+}
+
+func FuzzStrictDecode(f *testing.F) {
+	for _, in := range []string{"\x00", "\x80\x01", "\x80\x00", "\xff\xff\xff\xff\xff\xff\xff\xff\xff\x01"} {
+		f.Add([]byte(in))
+	}
+	f.Fuzz(func(t *testing.T, in []byte) {
+		var v Strict
+		n, err := v.DecodeFrom(in)
+		if err != nil {
+			return
+		}
+		if b, _ := v.AppendBinary(nil); !bytes.Equal(b, in[:n]) {
+			t.Fatalf("%x: decoded %d, which encodes as %x", in[:n], v, b)
+		}
+	})
+}
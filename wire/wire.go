@@ -0,0 +1,110 @@
+// Package wire holds the types and functions used by the code wire9
+// generates. It depends only on the standard library, so generated
+// code does not import the generator.
+package wire
+
+import (
+	"fmt"
+	"io"
+)
+
+// DecodeError is returned by generated ReadBinary and DecodeFrom methods.
+// It reports the field that failed to decode and its offset from the
+// start of the message.
+type DecodeError struct {
+	Type   string // name of the wire definition
+	Field  string // name of the field
+	Offset int64  // offset of the field in bytes
+	Err    error  // the underlying error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("%s.%s at offset %d: %v", e.Type, e.Field, e.Offset, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// Annotate returns err as a *DecodeError for the named field of typ at
+// offset off. A *DecodeError from a nested definition keeps its field,
+// and its offset is made relative to the enclosing message.
+func Annotate(err error, typ, field string, off int64) error {
+	if e, ok := err.(*DecodeError); ok {
+		e2 := *e
+		e2.Offset += off
+		return &e2
+	}
+	return &DecodeError{Type: typ, Field: field, Offset: off, Err: err}
+}
+
+// LengthError is returned when a width read from the input exceeds the
+// maximum number of elements allowed in the field. For a field holding
+// the rest of the input, ReadBinary stops reading at Max+1 bytes.
+type LengthError struct {
+	Len int // width read from the input, or the bytes read of its rest
+	Max int // maximum width of the field
+}
+
+func (e *LengthError) Error() string {
+	return fmt.Sprintf("length %d exceeds maximum %d", e.Len, e.Max)
+}
+
+// chunk is the largest allocation ReadN makes ahead of the data
+const chunk = 64 << 10
+
+// ReadN reads n bytes from r with the semantics of io.ReadFull. Memory
+// for large n is allocated as the data arrives, so it grows with the
+// bytes actually read rather than with n. On error, the returned slice
+// holds the bytes read.
+func ReadN(r io.Reader, n int) ([]byte, error) {
+	if n <= chunk {
+		b := make([]byte, n)
+		m, err := io.ReadFull(r, b)
+		return b[:m], err
+	}
+	var b []byte
+	for len(b) < n {
+		i, m := len(b), n-len(b)
+		if m > chunk {
+			m = chunk
+		}
+		b = append(b, make([]byte, m)...)
+		k, err := io.ReadFull(r, b[i:])
+		b = b[:i+k]
+		if err == io.EOF && i != 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return b, err
+		}
+	}
+	return b, nil
+}
+
+// Counter is a reader counting the bytes read from R. Generated
+// ReadBinary methods use it to find the offset of a failed field.
+type Counter struct {
+	R io.Reader
+	N int64
+}
+
+func (c *Counter) Read(p []byte) (int, error) {
+	n, err := c.R.Read(p)
+	c.N += int64(n)
+	return n, err
+}
+
+// WriteCounter is a writer counting the bytes written to W. Generated
+// WriteBinary methods use it to find the offset of align fields.
+type WriteCounter struct {
+	W io.Writer
+	N int64
+}
+
+func (c *WriteCounter) Write(p []byte) (int, error) {
+	n, err := c.W.Write(p)
+	c.N += int64(n)
+	return n, err
+}
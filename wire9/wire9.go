@@ -16,10 +16,13 @@ var (
 	nofmt    = flag.Bool("d", false, "debug: no gofmt")
 	verbose  = flag.Bool("v", false, "debug: be verbose")
 	filename = flag.String("f", "", "output file name (default stdout")
+	slices   = flag.Bool("s", false, "generate DecodeFrom and AppendBinary")
+	maxlen   = flag.Int("max", 0, "default maximum length of variable-width and rest-of-input slices")
+	arrays   = flag.Bool("a", false, "make untyped fields wider than 8 bytes arrays")
 )
 
 func usage() {
-	fmt.Fprintf(os.Stderr, "usage: wire9 [-f outfile] [path ...]\n")
+	fmt.Fprintf(os.Stderr, "usage: wire9 [-s] [-a] [-max n] [-f outfile] [path ...]\n")
 	os.Exit(0)
 }
 
@@ -30,8 +33,11 @@ func init() {
 func main() {
 	a := flag.Args()
 	if len(a) == 0 {
-		log.Fatal("usage: wire9 [-d -v -f] package")
+		log.Fatal("usage: wire9 [-d -v -s -a -max -f] package")
 	}
+	wire9.Options.SliceCodec = *slices
+	wire9.Options.MaxLen = *maxlen
+	wire9.Options.Arrays = *arrays
 	dopackage(a[0])
 }
 
@@ -3,6 +3,12 @@ package wire9
 import (
 	"bytes"
 	"fmt"
+	"go/format"
+	goparser "go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -63,6 +69,210 @@ func TestErrPlan9Draw(t *testing.T) {
 	ck(t, "//wire9 DrawY id[16] r[256] buf[1]\n")
 }
 
+func TestDirective(t *testing.T) {
+	s := new(Source)
+	for _, line := range []string{"//wire9:endian BE", "//wire9:endian LE"} {
+		if _, err := s.ParseLine(line); err != nil {
+			t.Errorf("%s: %s", line, err)
+		}
+	}
+	for _, line := range []string{"//wire9:endian", "//wire9:endian XE", "//wire9:bogus", "//wire9:"} {
+		if _, err := s.ParseLine(line); err == nil {
+			t.Errorf("%s: expected error", line)
+		}
+	}
+	want := `endian must be "LE" or "BE", got "XE"`
+	if _, err := s.ParseLine("//wire9:endian XE"); err == nil || err.Error() != want {
+		t.Errorf("have %v, want %s", err, want)
+	}
+}
+
+func TestBitErrors(t *testing.T) {
+	for _, line := range []string{
+		"//wire9 B1 a[3b] b[1]\n",
+		"//wire9 B2 a[60b] b[12b]\n",
+		"//wire9 B3 a[2b,bool] b[6b]\n",
+		"//wire9 B4 a[9b,byte] b[7b]\n",
+		"//wire9 B5<XE> a[8b]\n",
+	} {
+		if _, err := new(Source).ParseLine(line); err == nil {
+			t.Errorf("%s: expected error", line)
+		}
+	}
+}
+
+func TestFieldErrors(t *testing.T) {
+	for _, line := range []string{
+		"//wire9 C1 a[1,,,if b] b[1]\n",
+		"//wire9 C2 a[1] b[4b,,,if a] c[4b]\n",
+		"//wire9 C3 a[1] b[1,,,if]\n",
+		"//wire9 C4 a[1] b[1,,,when a]\n",
+		"//wire9 R1 a[*] b[1]\n",
+		"//wire9 R2 a[*,[]uint16]\n",
+		"//wire9 F1 a[4,,,frame] b[4,,,frame]\n",
+		"//wire9 F2 a[3,,,frame]\n",
+		"//wire9 F3 a[1] b[1,,,frame,if a]\n",
+		"//wire9 F4 a[1,,,bogus]\n",
+		"//wire9 M1 a[4,,,max=8]\n",
+		"//wire9 M2 n[1] a[n,,,max=0]\n",
+		"//wire9 M3 n[1] a[n,,,max=n]\n",
+		"//wire9 M4 a[*,,,max=0]\n",
+		"//wire9 M5 n[1] a[n,,,max]\n",
+		"//wire9 K1 a[2,0x10000]\n",
+		"//wire9 K2 a[2,\"abc\"]\n",
+		"//wire9 K3 a[2b,1] b[6b]\n",
+		"//wire9 K4 n[1] a[n,\"x\"]\n",
+		"//wire9 K5 a[1,1] b[a]\n",
+		"//wire9 K6 a[,1]\n",
+		"//wire9 K7 a[1,1] b[1,,,if a]\n",
+		"//wire9 P1 a[1] _[*]\n",
+		"//wire9 P2 _[2,uint16]\n",
+		"//wire9 P3 align(0) a[1]\n",
+		"//wire9 P4 n[1] align(n)\n",
+		"//wire9 P5 _[4,,,frame]\n",
+		"//wire9 P6 _[,,BE]\n",
+		"//wire9 A1 a[3,[4]uint16]\n",
+		"//wire9 A2 n[1] a[n,[4]uint16]\n",
+		"//wire9 V1 n[uvarint,uint32]\n",
+		"//wire9 V2 n[svarint,,BE]\n",
+		"//wire9 V3 n[uvarint,,,frame]\n",
+		"//wire9 V4 _[uvarint]\n",
+	} {
+		if _, err := new(Source).ParseLine(line); err == nil {
+			t.Errorf("%s: expected error", line)
+		}
+	}
+}
+
+func TestPadErrors(t *testing.T) {
+	for _, tc := range []struct{ line, want string }{
+		{"//wire9 P1 a[4b] _[4b,,,if a]\n", "bit field _ cant be conditional"},
+		{"//wire9 P2 a[4b] _[2b]\n", "bit fields a.._: 6 bits is not a whole number of bytes"},
+	} {
+		_, err := new(Source).ParseLine(tc.line)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: have %v, want %s", tc.line, err, tc.want)
+		}
+	}
+}
+
+func TestUnsizedErrors(t *testing.T) {
+	for _, tc := range []struct{ line, want string }{
+		{"//wire9 U1 a[8,int]\n", "field a: type int has no fixed width"},
+		{"//wire9 U2 n[1] vals[n,[]int]\n", "field vals: type int has no fixed width"},
+		{"//wire9 U3 n[1] vals[n,[]uint,BE]\n", "field vals: type uint has no fixed width"},
+		{"//wire9 U4 vals[2,[2]uintptr]\n", "field vals: type uintptr has no fixed width"},
+	} {
+		_, err := new(Source).ParseLine(tc.line)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: have %v, want %s", tc.line, err, tc.want)
+		}
+	}
+}
+
+func TestPackageClause(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "proto.go")
+	src := "package proto\n\nimport pic \"image\"\n\n//wire9 Pt p[8,pic.Point] n[4]\n\nvar _ pic.Point\n"
+	if err := os.WriteFile(name, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	data, err := FromFiles([]string{name}, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := goparser.ParseFile(token.NewFileSet(), "", data, goparser.ImportsOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Name.Name != "proto" {
+		t.Errorf("package: have %s, want proto", f.Name.Name)
+	}
+	have := make(map[string]bool)
+	for _, im := range f.Imports {
+		spec := im.Path.Value
+		if im.Name != nil {
+			spec = im.Name.Name + " " + spec
+		}
+		have[spec] = true
+	}
+	for _, want := range []string{`"encoding/binary"`, `"io"`, `pic "image"`} {
+		if !have[want] {
+			t.Errorf("missing import %s", want)
+		}
+	}
+	if have[`"math"`] {
+		t.Errorf("unused import \"math\"")
+	}
+}
+
+func TestImportConflict(t *testing.T) {
+	gen := func(src string) (map[string]bool, error) {
+		name := filepath.Join(t.TempDir(), "proto.go")
+		if err := os.WriteFile(name, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+		data, err := FromFiles([]string{name}, nil, true)
+		if err != nil {
+			return nil, err
+		}
+		f, err := goparser.ParseFile(token.NewFileSet(), "", data, goparser.ImportsOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		have := make(map[string]bool)
+		for _, im := range f.Imports {
+			have[im.Path.Value] = true
+		}
+		return have, nil
+	}
+
+	// Imports the definitions do not refer to are not the generator's
+	have, err := gen("package proto\n\nimport (\n\t\"example.com/bytes\"\n\t\"example.com/varint\"\n)\n\n//wire9 A n[uvarint] data[n]\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"bytes"`, `"github.com/as/wire9/varint"`} {
+		if !have[want] {
+			t.Errorf("missing import %s", want)
+		}
+	}
+
+	// Types named in definitions come from the imports of the source
+	have, err = gen("package proto\n\nimport \"example.com/varint\"\n\n//wire9 B c[,varint.Count] data[c]\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !have[`"example.com/varint"`] || have[`"github.com/as/wire9/varint"`] {
+		t.Errorf("have imports %v, want example.com/varint", have)
+	}
+
+	// The generated code needs package bytes as well
+	if _, err := gen("package proto\n\nimport \"example.com/bytes\"\n\n//wire9 C b[,bytes.Buf]\n"); err == nil {
+		t.Errorf("expected a conflict for package name bytes")
+	}
+}
+
+func TestFormat(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "proto.go")
+	if err := os.WriteFile(name, []byte("package proto\n\n//wire9 Msg n[4] data[n]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	data, err := FromFiles([]string{name}, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src, err := format.Source(data); err != nil || !bytes.Equal(src, data) {
+		t.Errorf("output is not gofmt'd: %v", err)
+	}
+	raw, err := FromFiles([]string{name}, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(raw, data) {
+		t.Errorf("output is gofmt'd with dofmt unset")
+	}
+}
+
 func ck(t *testing.T, s string) {
 	err := testparse(s)
 	if err != nil {